@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/hofer/nats-llm/pkq/mcp"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"time"
+)
+
+var agentNatsUrl string
+var agentBackend string
+var agentModel string
+var agentServices []string
+var agentMCPServers []string
+var agentMaxSteps int
+var agentTimeout time.Duration
+
+var agentCmd = &cobra.Command{
+	Use:   "agent [prompt]",
+	Short: "Answer a prompt with an LLM using NATS micro services as tools",
+	Long: `Answer a prompt with an LLM exposed via NATS. The endpoints of NATS micro services with a JSON schema
+in their "schema" metadata are offered to the LLM as tools, its tool calls are sent as requests to these endpoints.
+The tools of MCP servers given with --mcp are offered as well, each server is launched as local subprocess.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := runAgent(strings.Join(args, " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// runAgent answers the prompt. Errors are returned rather than logged fatally, so the deferred cleanup
+// stops the subprocesses of the MCP servers.
+func runAgent(prompt string) error {
+	nc, err := nats.Connect(agentNatsUrl)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	var model llm.LLM
+	switch agentBackend {
+	case "ollama":
+		model = llm.NewNatsOllamaLLM(nc, agentModel)
+	case "gemini":
+		model = llm.NewNatsGeminiLLM(nc, agentModel)
+	default:
+		return fmt.Errorf("unknown backend '%s', expecting 'ollama' or 'gemini'", agentBackend)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentTimeout)
+	defer cancel()
+
+	agent := llm.NewAgent(model)
+	agent.MaxSteps = agentMaxSteps
+	agent.Parallel = true
+	err = agent.AddNatsTools(ctx, nc, llm.NatsToolsConfig{Services: agentServices})
+	if err != nil {
+		return err
+	}
+	for _, mcpServer := range agentMCPServers {
+		command := strings.Fields(mcpServer)
+		if len(command) == 0 {
+			continue
+		}
+		client, err := mcp.StartClient(ctx, command[0], command[1:]...)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := client.Close(); err != nil {
+				log.Warningf("Error stopping MCP server '%s': %v", mcpServer, err)
+			}
+		}()
+		err = agent.AddMCPTools(ctx, client)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := agent.Run(ctx, &api.ChatRequest{
+		Messages: []api.Message{{Role: "user", Content: prompt}},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(resp.Message.Content)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.Flags().StringVarP(&agentNatsUrl, "url", "u", os.Getenv("NATS_URL"), "URL to the Nats.io server")
+	agentCmd.Flags().StringVar(&agentBackend, "backend", "ollama", "Proxy of the LLM: 'ollama' or 'gemini'")
+	agentCmd.Flags().StringVarP(&agentModel, "model", "m", "", "Model answering the prompt")
+	agentCmd.MarkFlagRequired("model")
+	agentCmd.Flags().StringSliceVar(&agentServices, "service", []string{}, "Name of a NATS micro service offered as tools (default: all services)")
+	agentCmd.Flags().StringArrayVar(&agentMCPServers, "mcp", []string{}, "Command launching an MCP server whose tools are offered, e.g. 'npx -y @modelcontextprotocol/server-everything'")
+	agentCmd.Flags().IntVar(&agentMaxSteps, "maxSteps", 10, "Maximum number of chat requests")
+	agentCmd.Flags().DurationVar(&agentTimeout, "timeout", 5*time.Minute, "Maximum duration of the whole run")
+}
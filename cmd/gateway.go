@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"github.com/hofer/nats-llm/internal/gateway"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var gatewayNatsUrl string
+var gatewayListen string
+var gatewayAPIKeys string
+var gatewayAdminToken string
+var gatewayTimeout time.Duration
+
+var gatewayCmd = &cobra.Command{
+	Use:   "gateway",
+	Short: "Serve the OpenAI HTTP API, forwarding requests to the proxies via NATS",
+	Long: `Serve the chat completion, embedding and model endpoints of the OpenAI HTTP API. Requests for Gemini models
+are sent to the Gemini proxy, all others to the Ollama proxy. With --apiKeys, clients must send one of the API keys
+as bearer token and their requests are sent with the NATS identity of the key. Without --apiKeys, the gateway
+accepts all requests and therefore listens on localhost by default.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := gatewayConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = gateway.StartOpenAIGateway(gatewayNatsUrl, gatewayListen, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func gatewayConfig() (gateway.GatewayConfig, error) {
+	config := gateway.GatewayConfig{
+		AdminToken:     gatewayAdminToken,
+		RequestTimeout: gatewayTimeout,
+	}
+	if gatewayAPIKeys != "" {
+		apiKeys, err := gateway.LoadAPIKeys(gatewayAPIKeys)
+		if err != nil {
+			return config, err
+		}
+		config.APIKeys = apiKeys
+	}
+	return config, nil
+}
+
+func init() {
+	rootCmd.AddCommand(gatewayCmd)
+	gatewayCmd.PersistentFlags().StringVarP(&gatewayNatsUrl, "url", "u", os.Getenv("NATS_URL"), "URL to the Nats.io server")
+	gatewayCmd.PersistentFlags().StringVarP(&gatewayListen, "listen", "l", "127.0.0.1:8080", "Address the HTTP server listens on")
+	gatewayCmd.PersistentFlags().StringVar(&gatewayAPIKeys, "apiKeys", "", "JSON file mapping API keys to NATS identities (default: no authentication)")
+	gatewayCmd.PersistentFlags().StringVar(&gatewayAdminToken, "adminToken", os.Getenv("NATS_LLM_ADMIN_TOKEN"), "Admin token of the Ollama proxy, required to list its models")
+	gatewayCmd.PersistentFlags().DurationVar(&gatewayTimeout, "timeout", 5*time.Minute, "Maximum duration of a single request")
+}
@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/hofer/nats-llm/internal/gateway"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var gatewayollamaCmd = &cobra.Command{
+	Use:   "ollama",
+	Short: "Serve the Ollama HTTP API, forwarding requests to the proxies via NATS",
+	Long: `Serve the chat, generate, embed, show and tags endpoints of the Ollama HTTP API, so remote Ollama and Gemini
+proxies look like a local Ollama server. Without --listen, the gateway listens on the default address of Ollama.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := gatewayConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		listen := gatewayListen
+		if !cmd.Flags().Changed("listen") {
+			listen = "127.0.0.1:11434"
+		}
+		err = gateway.StartOllamaGateway(gatewayNatsUrl, listen, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	gatewayCmd.AddCommand(gatewayollamaCmd)
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"github.com/hofer/nats-llm/internal/gateway"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var gatewayAllowOrigins []string
+
+var gatewaywebsocketCmd = &cobra.Command{
+	Use:   "websocket",
+	Short: "Accept chat requests of browser clients on a WebSocket endpoint, forwarding them to the proxies via NATS",
+	Long: `Serve the WebSocket endpoint /ws for browser clients. Clients send chat requests as JSON frames
+{"id": "1", "type": "chat", "request": {...}} and receive "chunk" frames followed by a "done" or an "error" frame.
+Running requests are cancelled with {"id": "1", "type": "cancel"} or when the socket is closed. With --apiKeys,
+the API key is sent as "api_key" query parameter.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := gatewayConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.AllowedOrigins = gatewayAllowOrigins
+		err = gateway.StartWebSocketGateway(gatewayNatsUrl, gatewayListen, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	gatewayCmd.AddCommand(gatewaywebsocketCmd)
+	gatewaywebsocketCmd.Flags().StringSliceVar(&gatewayAllowOrigins, "allowOrigin", []string{}, "Origin of web pages allowed to connect besides the gateway itself, '*' for all")
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/hofer/nats-llm/pkq/mcp"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var mcpNatsUrl string
+var mcpBackends []string
+var mcpOllamaModel string
+var mcpGeminiModel string
+
+const mcpChatSchema = `{
+  "type": "object",
+  "properties": {
+    "prompt": {"type": "string", "description": "The prompt sent to the model"},
+    "system": {"type": "string", "description": "Optional system prompt"},
+    "model": {"type": "string", "description": "Name of the model%s"}
+  },
+  "required": ["prompt"]
+}`
+
+const mcpEmbedSchema = `{
+  "type": "object",
+  "properties": {
+    "input": {"type": "array", "items": {"type": "string"}, "description": "The texts to embed"},
+    "model": {"type": "string", "description": "Name of the embedding model%s"}
+  },
+  "required": ["input"]
+}`
+
+type mcpChatArgs struct {
+	Prompt string `json:"prompt"`
+	System string `json:"system"`
+	Model  string `json:"model"`
+}
+
+type mcpEmbedArgs struct {
+	Input []string `json:"input"`
+	Model string   `json:"model"`
+}
+
+var mcpCmd = &cobra.Command{
+	Use:   "mcp",
+	Short: "Offer the chat and embed endpoints of the proxies as MCP server over stdio",
+	Long: `Run a Model Context Protocol (MCP) server over stdin and stdout, offering the chat endpoints of the Ollama and
+Gemini proxies and the embed endpoint of the Ollama proxy as tools, e.g. "ollama_chat" and "ollama_embed". Logs are
+written to stderr.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		nc, err := nats.Connect(mcpNatsUrl)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer nc.Close()
+
+		server := mcp.NewServer("nats-llm", "1.0.0")
+		for _, backend := range mcpBackends {
+			switch backend {
+			case "ollama":
+				addMCPLLMTools(server, backend, mcpOllamaModel, true, func(model string) llm.LLM { return llm.NewNatsOllamaLLM(nc, model) })
+			case "gemini":
+				// The Gemini proxy has no embed endpoint:
+				addMCPLLMTools(server, backend, mcpGeminiModel, false, func(model string) llm.LLM { return llm.NewNatsGeminiLLM(nc, model) })
+			default:
+				log.Fatalf("Unknown backend '%s', expecting 'ollama' or 'gemini'", backend)
+			}
+		}
+
+		err = server.Serve(context.Background(), os.Stdin, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// addMCPLLMTools adds the chat tool of a backend to the server, and its embed tool if the backend serves
+// embeddings.
+func addMCPLLMTools(server *mcp.Server, backend string, defaultModel string, embed bool, newLLM func(model string) llm.LLM) {
+	defaultDescription := ""
+	if defaultModel != "" {
+		defaultDescription = fmt.Sprintf(" (default: %s)", defaultModel)
+	}
+	modelName := func(model string) (string, error) {
+		if model != "" {
+			return model, nil
+		}
+		if defaultModel == "" {
+			return "", errors.New("no model given")
+		}
+		return defaultModel, nil
+	}
+
+	server.AddTool(mcp.Tool{
+		Name:        backend + "_chat",
+		Description: fmt.Sprintf("Send a prompt to an LLM served by the %s proxy and return its answer", backend),
+		InputSchema: json.RawMessage(fmt.Sprintf(mcpChatSchema, defaultDescription)),
+	}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var chatArgs mcpChatArgs
+		err := json.Unmarshal(arguments, &chatArgs)
+		if err != nil {
+			return "", err
+		}
+		model, err := modelName(chatArgs.Model)
+		if err != nil {
+			return "", err
+		}
+
+		messages := []api.Message{}
+		if chatArgs.System != "" {
+			messages = append(messages, api.Message{Role: "system", Content: chatArgs.System})
+		}
+		messages = append(messages, api.Message{Role: "user", Content: chatArgs.Prompt})
+		resp, err := newLLM(model).Chat(ctx, &api.ChatRequest{Messages: messages})
+		if err != nil {
+			return "", err
+		}
+		return resp.Message.Content, nil
+	})
+
+	if !embed {
+		return
+	}
+	server.AddTool(mcp.Tool{
+		Name:        backend + "_embed",
+		Description: fmt.Sprintf("Create embeddings of texts with a model served by the %s proxy", backend),
+		InputSchema: json.RawMessage(fmt.Sprintf(mcpEmbedSchema, defaultDescription)),
+	}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var embedArgs mcpEmbedArgs
+		err := json.Unmarshal(arguments, &embedArgs)
+		if err != nil {
+			return "", err
+		}
+		model, err := modelName(embedArgs.Model)
+		if err != nil {
+			return "", err
+		}
+
+		resp, err := newLLM(model).Embed(ctx, &api.EmbedRequest{Input: embedArgs.Input})
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(resp.Embeddings)
+		return string(data), err
+	})
+}
+
+func init() {
+	rootCmd.AddCommand(mcpCmd)
+	mcpCmd.Flags().StringVarP(&mcpNatsUrl, "url", "u", os.Getenv("NATS_URL"), "URL to the Nats.io server")
+	mcpCmd.Flags().StringSliceVar(&mcpBackends, "backend", []string{"ollama", "gemini"}, "Proxies whose endpoints are offered as tools: 'ollama' and/or 'gemini'")
+	mcpCmd.Flags().StringVar(&mcpOllamaModel, "ollamaModel", "", "Model of the Ollama tools if the tool call names none")
+	mcpCmd.Flags().StringVar(&mcpGeminiModel, "geminiModel", "", "Model of the Gemini tools if the tool call names none")
+}
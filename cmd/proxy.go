@@ -4,9 +4,16 @@ import (
 	"fmt"
 	"github.com/spf13/cobra"
 	"os"
+	"time"
 )
 
 var proxyNatsUrl string
+var proxyAllowModels []string
+var proxyDenyModels []string
+var proxySessions bool
+var proxySessionTTL time.Duration
+var proxySummaryModel string
+var proxyAdminToken string
 
 var proxyCmd = &cobra.Command{
 	Use:   "proxy",
@@ -26,4 +33,10 @@ func init() {
 	rootCmd.AddCommand(proxyCmd)
 	proxyCmd.PersistentFlags().StringVarP(&proxyNatsUrl, "url", "u", os.Getenv("NATS_URL"), "URL to the Nats.io server")
 	proxyCmd.MarkFlagRequired("url")
+	proxyCmd.PersistentFlags().StringSliceVar(&proxyAllowModels, "allowModel", []string{}, "Glob pattern of models clients are allowed to use (default: all models)")
+	proxyCmd.PersistentFlags().StringSliceVar(&proxyDenyModels, "denyModel", []string{}, "Glob pattern of models clients are not allowed to use")
+	proxyCmd.PersistentFlags().BoolVar(&proxySessions, "sessions", true, "Enable chat sessions stored in NATS KV (requires JetStream)")
+	proxyCmd.PersistentFlags().DurationVar(&proxySessionTTL, "sessionTTL", 24*time.Hour, "Duration after which sessions without updates expire (0 to keep them)")
+	proxyCmd.PersistentFlags().StringVar(&proxyAdminToken, "adminToken", os.Getenv("NATS_LLM_ADMIN_TOKEN"), "Token required to manage models and to list sessions (disabled if empty)")
+	proxyCmd.PersistentFlags().StringVar(&proxySummaryModel, "summaryModel", "", "Model summarizing the history of chat requests truncated with the 'summarize' strategy (default: model of the request)")
 }
@@ -5,10 +5,20 @@ import (
 	"github.com/nats-io/nats.go"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"google.golang.org/genai"
 	"os"
+	"time"
 )
 
 var apiKey string
+var modelListRefresh time.Duration
+var safetySettingsFile string
+var geminiBackend string
+var vertexProject string
+var vertexLocation string
+var vertexCredentialsFile string
+var cacheTTL time.Duration
+var cacheMinTokens int
 
 var proxyGenminiCmd = &cobra.Command{
 	Use:   "gemini",
@@ -26,7 +36,35 @@ to quickly create a Cobra application.`,
 			log.Fatal(err)
 		}
 
-		err = proxy.StartNatsGeminiProxy(nc, apiKey)
+		modelFilter, err := proxy.NewModelFilter(proxyAllowModels, proxyDenyModels)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var safetySettings []*genai.SafetySetting
+		if safetySettingsFile != "" {
+			safetySettings, err = proxy.LoadGeminiSafetySettings(safetySettingsFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		err = proxy.StartNatsGeminiProxy(nc, proxy.GeminiProxyConfig{
+			APIKey:           apiKey,
+			Backend:          geminiBackend,
+			Project:          vertexProject,
+			Location:         vertexLocation,
+			CredentialsFile:  vertexCredentialsFile,
+			Models:           modelFilter,
+			ModelListRefresh: modelListRefresh,
+			SafetySettings:   safetySettings,
+			CacheTTL:         cacheTTL,
+			CacheMinTokens:   cacheMinTokens,
+			Sessions:         proxySessions,
+			SessionTTL:       proxySessionTTL,
+			AdminToken:       proxyAdminToken,
+			SummaryModel:     proxySummaryModel,
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -36,4 +74,12 @@ to quickly create a Cobra application.`,
 func init() {
 	proxyCmd.AddCommand(proxyGenminiCmd)
 	proxyGenminiCmd.PersistentFlags().StringVarP(&apiKey, "apiKey", "k", os.Getenv("GEMINI_API_KEY"), "Gemini API key")
+	proxyGenminiCmd.PersistentFlags().DurationVar(&modelListRefresh, "modelListRefresh", time.Hour, "Interval at which the list of Gemini models is reloaded")
+	proxyGenminiCmd.PersistentFlags().StringVar(&safetySettingsFile, "safetySettings", "", "JSON file with the default safety settings of Gemini chat requests")
+	proxyGenminiCmd.PersistentFlags().StringVar(&geminiBackend, "backend", proxy.GeminiBackendAPI, "Backend of the proxy: 'gemini' for the Gemini API or 'vertex' for Vertex AI")
+	proxyGenminiCmd.PersistentFlags().StringVar(&vertexProject, "project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "Google Cloud project of Vertex AI")
+	proxyGenminiCmd.PersistentFlags().StringVar(&vertexLocation, "location", os.Getenv("GOOGLE_CLOUD_LOCATION"), "Google Cloud location of Vertex AI, e.g. europe-west6")
+	proxyGenminiCmd.PersistentFlags().StringVar(&vertexCredentialsFile, "credentials", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), "Service account key file for Vertex AI (default: application default credentials)")
+	proxyGenminiCmd.PersistentFlags().DurationVar(&cacheTTL, "cacheTTL", time.Hour, "Lifetime of Gemini cached contents for large prompt prefixes, 0 disables context caching")
+	proxyGenminiCmd.PersistentFlags().IntVar(&cacheMinTokens, "cacheMinTokens", 4096, "Estimated number of tokens from which a prompt prefix is cached")
 }
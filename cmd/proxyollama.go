@@ -4,9 +4,12 @@ import (
 	"github.com/hofer/nats-llm/internal/proxy"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 var proxyOllamaUrl string
+var proxyOllamaAllowPull bool
+var proxyOllamaPullTimeout time.Duration
 
 // proxyollamaCmd represents the proxyollama command
 var proxyollamaCmd = &cobra.Command{
@@ -21,7 +24,20 @@ to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Infof("Connecting to the Nats.io Server: %s", proxyNatsUrl)
 		log.Infof("Connecting to Ollama on url: %s", proxyOllamaUrl)
-		err := proxy.StartOllamaProxy(proxyNatsUrl, proxyOllamaUrl)
+		modelFilter, err := proxy.NewModelFilter(proxyAllowModels, proxyDenyModels)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = proxy.StartOllamaProxy(proxyNatsUrl, proxyOllamaUrl, proxy.OllamaProxyConfig{
+			Models:       modelFilter,
+			AllowPull:    proxyOllamaAllowPull,
+			PullTimeout:  proxyOllamaPullTimeout,
+			AdminToken:   proxyAdminToken,
+			Sessions:     proxySessions,
+			SessionTTL:   proxySessionTTL,
+			SummaryModel: proxySummaryModel,
+		})
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -31,4 +47,6 @@ to quickly create a Cobra application.`,
 func init() {
 	proxyCmd.AddCommand(proxyollamaCmd)
 	proxyollamaCmd.PersistentFlags().StringVarP(&proxyOllamaUrl, "ollamaUrl", "o", "http://localhost:11434", "URL to the Nats.io server")
+	proxyollamaCmd.PersistentFlags().DurationVar(&proxyOllamaPullTimeout, "pullTimeout", 30*time.Minute, "Maximum duration of an automatic model pull (0 for no limit)")
+	proxyollamaCmd.PersistentFlags().BoolVar(&proxyOllamaAllowPull, "allowPull", true, "Automatically pull models which are not available in Ollama")
 }
@@ -24,11 +24,17 @@ func main() {
 	// Example to chat with Gemini
 	chatWithGemini(nc)
 
+	// Example to chat with Gemini within a session stored by the proxy
+	chatWithGeminiInSession(nc)
+
 	// Example with tool calling in Ollama
 	toolCallingWithOllama(nc)
 
 	// Example with tool calling in Gemini
 	toolCallingWithGemini(nc)
+
+	// Example with an agent executing the tool calls
+	agentWithGemini(nc)
 }
 
 // Note: This example is deliberately verbose, so it is easy to understand:
@@ -95,6 +101,45 @@ func chatWithGemini(nc *nats.Conn) {
 	log.Infof("Second response from LLM: %s", geminiRes2.Message.Content)
 }
 
+// The proxy keeps the history of a session, so the image is only sent once:
+func chatWithGeminiInSession(nc *nats.Conn) {
+	geminiLlm := llm.NewNatsGeminiLLM(nc, "gemini-2.5-flash")
+	sessions := llm.NewNatsSessions(nc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+	session, err := sessions.Create(ctx, &llm.SessionCreateRequest{Model: "gemini-2.5-flash"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Sessions expire after the session TTL of the proxy, but can be deleted earlier:
+	defer sessions.Delete(context.Background(), session.ID)
+
+	sessionCtx := llm.ContextWithSession(ctx, session.ID)
+	geminiRes1, err1 := geminiLlm.Chat(sessionCtx, &api.ChatRequest{
+		Messages: []api.Message{{
+			Role:    "user",
+			Content: "What is the man in the middle holding in his hands?",
+			Images:  []api.ImageData{readImageData()},
+		}},
+	})
+	if err1 != nil {
+		log.Fatal(err1)
+	}
+	log.Infof("First response from LLM: %s", geminiRes1.Message.Content)
+
+	geminiRes2, err2 := geminiLlm.Chat(sessionCtx, &api.ChatRequest{
+		Messages: []api.Message{{
+			Role:    "user",
+			Content: "Is the person on the left holding flowers as well?",
+		}},
+	})
+	if err2 != nil {
+		log.Fatal(err2)
+	}
+	log.Infof("Second response from LLM: %s", geminiRes2.Message.Content)
+}
+
 func readImageData() api.ImageData {
 	filePath := "example.png"
 	file, err := os.Open(filePath)
@@ -134,8 +179,9 @@ func toolCallingWithOllama(nc *nats.Conn) {
 
 	toolResult := `{"status": "success", "data": "21 degrees celsius.", "name": "get_temperature"}` // Example tool output (often JSON)
 	secondMessage := api.Message{
-		Role:    "tool",
-		Content: toolResult,
+		Role:     "tool",
+		ToolName: "get_temperature",
+		Content:  toolResult,
 	}
 	ollamaRes2, err2 := ollamaLlm.Chat(ctx, &api.ChatRequest{
 		Messages: []api.Message{
@@ -173,8 +219,9 @@ func toolCallingWithGemini(nc *nats.Conn) {
 
 	toolResult := `{"status": "success", "data": "21 degrees celsius.", "name": "get_temperature"}` // Example tool output (often JSON)
 	secondMessage := api.Message{
-		Role:    "tool",
-		Content: toolResult,
+		Role:     "tool",
+		ToolName: "get_temperature",
+		Content:  toolResult,
 	}
 	geminiRes2, err2 := geminiLlm.Chat(ctx, &api.ChatRequest{
 		Messages: []api.Message{
@@ -190,6 +237,32 @@ func toolCallingWithGemini(nc *nats.Conn) {
 	log.Infof("Second response from LLM: %s", geminiRes2.Message.Content)
 }
 
+type temperatureArgs struct {
+	City string `json:"city" jsonschema:"description=The name of the city"`
+}
+
+// The agent executes the tool calls and sends their results back until the LLM gives a final answer:
+func agentWithGemini(nc *nats.Conn) {
+	agent := llm.NewAgent(llm.NewNatsGeminiLLM(nc, "gemini-2.5-flash"))
+	err := llm.RegisterTool(agent, "get_temperature", "Returns the current temperature for a given city name",
+		func(ctx context.Context, args temperatureArgs) (any, error) {
+			return map[string]any{"city": args.City, "temperature": "21 degrees celsius"}, nil
+		})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+	res, err := agent.Run(ctx, &api.ChatRequest{
+		Messages: []api.Message{{Role: "user", Content: "How warm is it in Bern?"}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("Response from the agent: %s", res.Message.Content)
+}
+
 func getTools() []api.Tool {
 	return []api.Tool{
 		{
@@ -0,0 +1,286 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GatewayConfig holds the settings of the HTTP gateways.
+type GatewayConfig struct {
+	// APIKeys maps the API keys accepted by the gateway to the NATS identities their requests are sent
+	// with. Without API keys, all requests are accepted and sent with the connection of the gateway.
+	APIKeys map[string]NatsIdentity
+
+	// AdminToken is sent with requests listing the models of the Ollama proxy. Without a token only
+	// the models of the Gemini proxy are listed.
+	AdminToken string
+
+	// RequestTimeout limits the duration of a single request, five minutes by default.
+	RequestTimeout time.Duration
+
+	// AllowedOrigins are the origins of web pages, besides the origin of the gateway itself, which may
+	// open WebSocket connections, e.g. "https://app.example.com". "*" allows all origins.
+	AllowedOrigins []string
+}
+
+// NatsIdentity holds the credentials of a NATS connection. An identity without any credentials uses the
+// connection of the gateway.
+type NatsIdentity struct {
+	User        string `json:"user,omitempty"`
+	Password    string `json:"password,omitempty"`
+	Token       string `json:"token,omitempty"`
+	Credentials string `json:"credentials,omitempty"`
+	NKeySeed    string `json:"nkey_seed,omitempty"`
+}
+
+func (i NatsIdentity) options() ([]nats.Option, error) {
+	options := []nats.Option{nats.Name("nats-llm-gateway")}
+	if i.User != "" {
+		options = append(options, nats.UserInfo(i.User, i.Password))
+	}
+	if i.Token != "" {
+		options = append(options, nats.Token(i.Token))
+	}
+	if i.Credentials != "" {
+		options = append(options, nats.UserCredentials(i.Credentials))
+	}
+	if i.NKeySeed != "" {
+		option, err := nats.NkeyOptionFromSeed(i.NKeySeed)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// LoadAPIKeys reads a JSON file mapping API keys to NATS identities, e.g.
+// {"sk-team-a": {"user": "team-a", "password": "secret"}, "sk-team-b": {"credentials": "team-b.creds"}}.
+func LoadAPIKeys(path string) (map[string]NatsIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var apiKeys map[string]NatsIdentity
+	err = json.Unmarshal(data, &apiKeys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys in '%s': %w", path, err)
+	}
+	return apiKeys, nil
+}
+
+const (
+	// maxRequestSize limits the size of a request body, which may contain images.
+	maxRequestSize = 32 * 1024 * 1024
+
+	// readHeaderTimeout limits the time a client may take to send the headers of a request.
+	readHeaderTimeout = 10 * time.Second
+)
+
+var (
+	errUnauthorized = errors.New("missing or invalid API key")
+
+	// errGeminiEmbeddings is returned for embedding requests of Gemini models, as the Gemini proxy has no
+	// embed endpoint.
+	errGeminiEmbeddings = errors.New("embeddings are only supported for Ollama models")
+)
+
+// newServer creates the HTTP server of a gateway, limiting the time to read the headers of a request.
+func newServer(listen string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              listen,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+}
+
+// Gateway translates HTTP requests to requests of the nats-llm proxies.
+type Gateway struct {
+	natsUrl string
+	config  GatewayConfig
+	nc      *nats.Conn
+
+	mu    sync.Mutex
+	conns map[string]*nats.Conn
+
+	// newLLM returns the client of the proxy serving a model, it is replaced in tests.
+	newLLM func(nc *nats.Conn, model string) llm.LLM
+}
+
+func NewGateway(natsUrl string, nc *nats.Conn, config GatewayConfig) *Gateway {
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = 5 * time.Minute
+	}
+	return &Gateway{
+		natsUrl: natsUrl,
+		config:  config,
+		nc:      nc,
+		conns:   map[string]*nats.Conn{},
+		newLLM:  llmForModel,
+	}
+}
+
+// Close closes the connections of the API keys.
+func (g *Gateway) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, conn := range g.conns {
+		conn.Close()
+		delete(g.conns, key)
+	}
+}
+
+// errorWriter writes an error in the format of the API served by a gateway.
+type errorWriter func(w http.ResponseWriter, status int, err error)
+
+// connHandler handles a request with the NATS connection of its API key.
+type connHandler func(w http.ResponseWriter, r *http.Request, nc *nats.Conn)
+
+// withConn authenticates the request with its API key, sent as bearer token, and passes it on with the
+// connection of the key, a context limited to the request timeout and a body limited to maxRequestSize.
+func (g *Gateway) withConn(writeError errorWriter, handler connHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nc, err := g.conn(r)
+		if errors.Is(err, errUnauthorized) {
+			log.Warningf("Rejected unauthorized request on '%s'", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if err != nil {
+			log.Error("Error connecting to NATS:", err)
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), g.config.RequestTimeout)
+		defer cancel()
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		handler(w, r.WithContext(ctx), nc)
+	}
+}
+
+// conn returns the NATS connection of the API key of the request.
+func (g *Gateway) conn(r *http.Request) (*nats.Conn, error) {
+	apiKey, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		apiKey = ""
+	}
+	return g.connForAPIKey(apiKey)
+}
+
+// connForAPIKey returns the NATS connection of the API key, connecting with its identity if needed.
+func (g *Gateway) connForAPIKey(apiKey string) (*nats.Conn, error) {
+	if len(g.config.APIKeys) == 0 {
+		return g.nc, nil
+	}
+	if apiKey == "" {
+		return nil, errUnauthorized
+	}
+	identity, ok := g.identity(apiKey)
+	if !ok {
+		return nil, errUnauthorized
+	}
+	if identity == (NatsIdentity{}) {
+		return g.nc, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if conn, ok := g.conns[apiKey]; ok && !conn.IsClosed() {
+		return conn, nil
+	}
+	options, err := identity.options()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := nats.Connect(g.natsUrl, options...)
+	if err != nil {
+		return nil, err
+	}
+	g.conns[apiKey] = conn
+	return conn, nil
+}
+
+// identity looks up the identity of an API key, comparing the key in constant time.
+func (g *Gateway) identity(apiKey string) (NatsIdentity, bool) {
+	for key, identity := range g.config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return identity, true
+		}
+	}
+	return NatsIdentity{}, false
+}
+
+// isGeminiModel returns true if the model is served by the Gemini proxy. All other models are sent to
+// the Ollama proxy.
+func isGeminiModel(model string) bool {
+	return strings.HasPrefix(model, "gemini") || strings.HasPrefix(model, "models/gemini")
+}
+
+// llmForModel returns a client of the proxy serving the model.
+func llmForModel(nc *nats.Conn, model string) llm.LLM {
+	if isGeminiModel(model) {
+		return llm.NewNatsGeminiLLM(nc, model)
+	}
+	return llm.NewNatsOllamaLLM(nc, model)
+}
+
+// listModels lists the models of both proxies. A proxy which cannot be reached is skipped.
+func (g *Gateway) listModels(ctx context.Context, nc *nats.Conn) []api.ListModelResponse {
+	models := []api.ListModelResponse{}
+	if g.config.AdminToken != "" {
+		ollamaModels, err := llm.NewNatsOllamaLLM(nc, "").List(llm.ContextWithAdminToken(ctx, g.config.AdminToken))
+		if err != nil {
+			log.Warningf("Cannot list the models of the Ollama proxy: %v", err)
+		}
+		models = append(models, ollamaModels.Models...)
+	}
+
+	geminiModels, err := llm.NewNatsGeminiLLM(nc, "").List(ctx)
+	if err != nil {
+		log.Warningf("Cannot list the models of the Gemini proxy: %v", err)
+	}
+	for _, model := range geminiModels.Models {
+		models = append(models, model.ListModelResponse)
+	}
+	return models
+}
+
+// httpStatus maps an error of a proxy request to the status of the HTTP response.
+func httpStatus(err error) int {
+	var serviceErr *llm.ServiceError
+	switch {
+	case errors.As(err, &serviceErr):
+		status, convErr := strconv.Atoi(serviceErr.Code)
+		if convErr == nil && status >= 400 && status < 600 {
+			return status
+		}
+	case errors.Is(err, nats.ErrNoResponders):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Error("Error sending response:", err)
+	}
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGatewayAuthentication(t *testing.T) {
+	gateway := NewGateway("", nil, GatewayConfig{APIKeys: map[string]NatsIdentity{"sk-valid": {}}})
+	called := false
+	handler := gateway.withConn(writeOpenAIError, func(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tt := []struct {
+		testName       string
+		inHeader       string
+		expectedStatus int
+	}{
+		{testName: "missing key", expectedStatus: http.StatusUnauthorized},
+		{testName: "invalid key", inHeader: "Bearer sk-invalid", expectedStatus: http.StatusUnauthorized},
+		{testName: "key without bearer prefix", inHeader: "sk-valid", expectedStatus: http.StatusUnauthorized},
+		{testName: "valid key", inHeader: "Bearer sk-valid", expectedStatus: http.StatusOK},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			if td.inHeader != "" {
+				req.Header.Set("Authorization", td.inHeader)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, req)
+
+			assert.Equal(t, td.expectedStatus, recorder.Code)
+			assert.Equal(t, td.expectedStatus == http.StatusOK, called)
+		})
+	}
+}
+
+func TestGatewayRequestSize(t *testing.T) {
+	gateway := NewGateway("", nil, GatewayConfig{})
+	handler := gateway.withConn(writeOpenAIError, func(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+		_, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeOpenAIError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tt := []struct {
+		testName       string
+		inSize         int
+		expectedStatus int
+	}{
+		{testName: "maximum size", inSize: maxRequestSize, expectedStatus: http.StatusOK},
+		{testName: "too large", inSize: maxRequestSize + 1, expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(make([]byte, td.inSize)))
+			recorder := httptest.NewRecorder()
+			handler(recorder, req)
+
+			assert.Equal(t, td.expectedStatus, recorder.Code)
+		})
+	}
+}
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"sk-a": {"user": "a", "password": "secret"}, "sk-b": {"credentials": "b.creds"}}`), 0600))
+
+	apiKeys, err := LoadAPIKeys(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]NatsIdentity{
+		"sk-a": {User: "a", Password: "secret"},
+		"sk-b": {Credentials: "b.creds"},
+	}, apiKeys)
+}
+
+func TestHttpStatus(t *testing.T) {
+	assert.Equal(t, http.StatusUnprocessableEntity, httpStatus(&llm.ServiceError{Code: "422"}))
+	assert.Equal(t, http.StatusBadGateway, httpStatus(&llm.ServiceError{Code: "unknown"}))
+	assert.Equal(t, http.StatusServiceUnavailable, httpStatus(nats.ErrNoResponders))
+	assert.Equal(t, http.StatusGatewayTimeout, httpStatus(context.DeadlineExceeded))
+	assert.Equal(t, http.StatusBadGateway, httpStatus(errors.New("failed")))
+}
+
+func TestIsGeminiModel(t *testing.T) {
+	assert.True(t, isGeminiModel("gemini-2.5-flash"))
+	assert.True(t, isGeminiModel("models/gemini-2.5-pro"))
+	assert.False(t, isGeminiModel("gemma3:27b"))
+}
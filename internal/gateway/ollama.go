@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// ollamaVersion is the version of the Ollama API served by the gateway.
+const ollamaVersion = "0.12.3"
+
+func StartOllamaGateway(natsUrl string, listen string, config GatewayConfig) error {
+	nc, err := nats.Connect(natsUrl, nats.Name("nats-llm-gateway"))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	gateway := NewGateway(natsUrl, nc, config)
+	defer gateway.Close()
+	log.Infof("Starting Ollama compatible gateway on '%s'...", listen)
+	return newServer(listen, gateway.OllamaHandler()).ListenAndServe()
+}
+
+// OllamaHandler serves the chat, generate, embed, show and tags endpoints of the Ollama API, so the
+// proxies look like a local Ollama server.
+func (g *Gateway) OllamaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chat", g.withConn(writeOllamaError, g.ollamaChatHandler))
+	mux.HandleFunc("POST /api/generate", g.withConn(writeOllamaError, g.ollamaGenerateHandler))
+	mux.HandleFunc("POST /api/embed", g.withConn(writeOllamaError, g.ollamaEmbedHandler))
+	mux.HandleFunc("POST /api/show", g.withConn(writeOllamaError, g.ollamaShowHandler))
+	mux.HandleFunc("GET /api/tags", g.withConn(writeOllamaError, g.ollamaTagsHandler))
+	mux.HandleFunc("GET /api/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"version": ollamaVersion})
+	})
+	// Clients check if the server is running with a request to the root path:
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Ollama is running")
+	})
+	return mux
+}
+
+// writeOllamaError writes an error in the format of Ollama, which only has an "error" field.
+func writeOllamaError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (g *Gateway) ollamaChatHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	var reqData api.ChatRequest
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err)
+		return
+	}
+	stream := reqData.Stream
+
+	resp, err := g.newLLM(nc, reqData.Model).Chat(r.Context(), &reqData)
+	if err != nil {
+		log.Error("Error sending chat request:", err)
+		writeOllamaError(w, httpStatus(err), err)
+		return
+	}
+
+	writeOllamaResponse(w, stream, resp, chatChunks(resp)...)
+}
+
+// chatChunks splits a chat response into a chunk with the message and a final chunk without content,
+// carrying the metrics, as in streamed responses of Ollama.
+func chatChunks(resp api.ChatResponse) []api.ChatResponse {
+	content := resp
+	content.Done = false
+	content.DoneReason = ""
+	content.Metrics = api.Metrics{}
+	final := resp
+	final.Message = api.Message{Role: resp.Message.Role}
+	return []api.ChatResponse{content, final}
+}
+
+func (g *Gateway) ollamaGenerateHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	var reqData api.GenerateRequest
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err)
+		return
+	}
+	stream := reqData.Stream
+
+	var resp api.GenerateResponse
+	if isGeminiModel(reqData.Model) {
+		if reqData.Raw || reqData.Suffix != "" || reqData.Template != "" || len(reqData.Context) > 0 {
+			writeOllamaError(w, http.StatusBadRequest, errors.New("raw, suffix, template and context are only supported by Ollama models"))
+			return
+		}
+		resp, err = generateWithChat(r, llm.NewNatsGeminiLLM(nc, reqData.Model), reqData)
+	} else {
+		resp, err = llm.NewNatsOllamaLLM(nc, reqData.Model).Generate(r.Context(), &reqData)
+	}
+	if err != nil {
+		log.Error("Error sending generate request:", err)
+		writeOllamaError(w, httpStatus(err), err)
+		return
+	}
+
+	content := resp
+	content.Done = false
+	content.DoneReason = ""
+	content.Context = nil
+	content.Metrics = api.Metrics{}
+	final := resp
+	final.Response = ""
+	final.Thinking = ""
+	final.ToolCalls = nil
+	writeOllamaResponse(w, stream, resp, content, final)
+}
+
+// generateWithChat sends a generate request as chat request, for proxies without a generate endpoint.
+func generateWithChat(r *http.Request, model llm.LLM, reqData api.GenerateRequest) (api.GenerateResponse, error) {
+	resp, err := model.Chat(r.Context(), createGenerateChatRequest(reqData))
+	if err != nil {
+		return api.GenerateResponse{}, err
+	}
+	return api.GenerateResponse{
+		Model:      resp.Model,
+		CreatedAt:  resp.CreatedAt,
+		Response:   resp.Message.Content,
+		Thinking:   resp.Message.Thinking,
+		Done:       resp.Done,
+		DoneReason: resp.DoneReason,
+		Metrics:    resp.Metrics,
+		ToolCalls:  resp.Message.ToolCalls,
+	}, nil
+}
+
+func createGenerateChatRequest(reqData api.GenerateRequest) *api.ChatRequest {
+	messages := []api.Message{}
+	if reqData.System != "" {
+		messages = append(messages, api.Message{Role: "system", Content: reqData.System})
+	}
+	messages = append(messages, api.Message{Role: "user", Content: reqData.Prompt, Images: reqData.Images})
+	return &api.ChatRequest{
+		Model:     reqData.Model,
+		Messages:  messages,
+		Format:    reqData.Format,
+		KeepAlive: reqData.KeepAlive,
+		Options:   reqData.Options,
+		Think:     reqData.Think,
+	}
+}
+
+func (g *Gateway) ollamaEmbedHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	var reqData api.EmbedRequest
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err)
+		return
+	}
+	if isGeminiModel(reqData.Model) {
+		writeOllamaError(w, http.StatusBadRequest, errGeminiEmbeddings)
+		return
+	}
+
+	resp, err := g.newLLM(nc, reqData.Model).Embed(r.Context(), &reqData)
+	if err != nil {
+		log.Error("Error sending embed request:", err)
+		writeOllamaError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (g *Gateway) ollamaShowHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	var reqData api.ShowRequest
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		writeOllamaError(w, http.StatusBadRequest, err)
+		return
+	}
+	// Older clients send the model as name:
+	if reqData.Model == "" {
+		reqData.Model = reqData.Name
+	}
+
+	resp, err := g.newLLM(nc, reqData.Model).Show(r.Context(), &reqData)
+	if err != nil {
+		log.Error("Error sending show request:", err)
+		writeOllamaError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (g *Gateway) ollamaTagsHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	writeJSON(w, http.StatusOK, api.ListResponse{Models: g.listModels(r.Context(), nc)})
+}
+
+// writeOllamaResponse sends the complete response, or the given chunks as newline delimited JSON if
+// streaming is requested, which is the default of the Ollama API. The proxies do not stream, so the
+// whole content arrives in a single chunk.
+func writeOllamaResponse[T any](w http.ResponseWriter, stream *bool, resp T, chunks ...T) {
+	if stream != nil && !*stream {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, chunk := range chunks {
+		err := encoder.Encode(chunk)
+		if err != nil {
+			log.Error("Error sending response:", err)
+			return
+		}
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
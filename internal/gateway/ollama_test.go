@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateGenerateChatRequest(t *testing.T) {
+	chatReq := createGenerateChatRequest(api.GenerateRequest{
+		Model:   "gemini-2.5-flash",
+		System:  "Answer briefly.",
+		Prompt:  "What is in this image?",
+		Images:  []api.ImageData{[]byte("png")},
+		Format:  json.RawMessage(`"json"`),
+		Options: map[string]any{"temperature": 0.1},
+	})
+
+	assert.Equal(t, &api.ChatRequest{
+		Model: "gemini-2.5-flash",
+		Messages: []api.Message{
+			{Role: "system", Content: "Answer briefly."},
+			{Role: "user", Content: "What is in this image?", Images: []api.ImageData{[]byte("png")}},
+		},
+		Format:  json.RawMessage(`"json"`),
+		Options: map[string]any{"temperature": 0.1},
+	}, chatReq)
+}
+
+func TestWriteOllamaResponse(t *testing.T) {
+	resp := api.ChatResponse{Message: api.Message{Role: "assistant", Content: "Hello"}, Done: true, DoneReason: "stop"}
+	content := api.ChatResponse{Message: resp.Message}
+	final := api.ChatResponse{Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop"}
+
+	recorder := httptest.NewRecorder()
+	writeOllamaResponse(recorder, new(bool), resp, content, final)
+	var single api.ChatResponse
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &single))
+	assert.Equal(t, "Hello", single.Message.Content)
+	assert.True(t, single.Done)
+
+	recorder = httptest.NewRecorder()
+	writeOllamaResponse(recorder, nil, resp, content, final)
+	assert.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+	chunks := []api.ChatResponse{}
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var chunk api.ChatResponse
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &chunk))
+		chunks = append(chunks, chunk)
+	}
+	assert.Equal(t, []api.ChatResponse{content, final}, chunks)
+}
+
+func TestOllamaHandlerHeartbeat(t *testing.T) {
+	handler := NewGateway("", nil, GatewayConfig{}).OllamaHandler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "Ollama is running", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+	assert.JSONEq(t, `{"version": "0.12.3"}`, recorder.Body.String())
+}
+
+func TestOllamaEmbedGeminiModel(t *testing.T) {
+	gateway := NewGateway("", nil, GatewayConfig{})
+	req := httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model": "gemini-embedding-001", "input": "Hello"}`))
+	recorder := httptest.NewRecorder()
+
+	gateway.OllamaHandler().ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.JSONEq(t, `{"error": "embeddings are only supported for Ollama models"}`, recorder.Body.String())
+}
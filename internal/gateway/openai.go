@@ -0,0 +1,570 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+func StartOpenAIGateway(natsUrl string, listen string, config GatewayConfig) error {
+	nc, err := nats.Connect(natsUrl, nats.Name("nats-llm-gateway"))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	gateway := NewGateway(natsUrl, nc, config)
+	defer gateway.Close()
+	log.Infof("Starting OpenAI compatible gateway on '%s'...", listen)
+	return newServer(listen, gateway.OpenAIHandler()).ListenAndServe()
+}
+
+// OpenAIHandler serves the chat completion, embedding and model endpoints of the OpenAI API.
+func (g *Gateway) OpenAIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", g.withConn(writeOpenAIError, g.openAIChatHandler))
+	mux.HandleFunc("POST /v1/embeddings", g.withConn(writeOpenAIError, g.openAIEmbeddingsHandler))
+	mux.HandleFunc("GET /v1/models", g.withConn(writeOpenAIError, g.openAIModelsHandler))
+	return mux
+}
+
+// openAIChatRequest is the part of an OpenAI chat completion request supported by the gateway.
+type openAIChatRequest struct {
+	Model               string                `json:"model"`
+	Messages            []openAIMessage       `json:"messages"`
+	Stream              bool                  `json:"stream,omitempty"`
+	StreamOptions       *openAIStreamOptions  `json:"stream_options,omitempty"`
+	Tools               []openAITool          `json:"tools,omitempty"`
+	ResponseFormat      *openAIResponseFormat `json:"response_format,omitempty"`
+	N                   *int                  `json:"n,omitempty"`
+	Temperature         *float64              `json:"temperature,omitempty"`
+	TopP                *float64              `json:"top_p,omitempty"`
+	MaxTokens           *int                  `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int                  `json:"max_completion_tokens,omitempty"`
+	Stop                json.RawMessage       `json:"stop,omitempty"`
+	Seed                *int                  `json:"seed,omitempty"`
+	FrequencyPenalty    *float64              `json:"frequency_penalty,omitempty"`
+	PresencePenalty     *float64              `json:"presence_penalty,omitempty"`
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name   string          `json:"name,omitempty"`
+		Schema json.RawMessage `json:"schema"`
+	} `json:"json_schema,omitempty"`
+}
+
+// openAIMessage is a message of a chat. The content is either a string or a list of content parts.
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *openAIResponseMessage `json:"message,omitempty"`
+	Delta        *openAIResponseMessage `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+type openAIResponseMessage struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content"`
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIEmbeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	EncodingFormat string          `json:"encoding_format,omitempty"`
+	Dimensions     int             `json:"dimensions,omitempty"`
+}
+
+type openAIEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []openAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  openAIUsage       `json:"usage"`
+}
+
+type openAIEmbedding struct {
+	Object    string `json:"object"`
+	Index     int    `json:"index"`
+	Embedding any    `json:"embedding"`
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIModelList struct {
+	Object string        `json:"object"`
+	Data   []openAIModel `json:"data"`
+}
+
+type openAIError struct {
+	Error openAIErrorDetails `json:"error"`
+}
+
+type openAIErrorDetails struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, err error) {
+	errorType := "api_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case status == http.StatusUnprocessableEntity:
+		errorType = "content_filter"
+	case status < 500:
+		errorType = "invalid_request_error"
+	}
+	writeJSON(w, status, openAIError{Error: openAIErrorDetails{Message: err.Error(), Type: errorType}})
+}
+
+func (g *Gateway) openAIChatHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	var reqData openAIChatRequest
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+	chatReq, err := createOllamaChatRequest(reqData)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Only Gemini returns several candidates for a single request:
+	var resp llm.GeminiChatResponse
+	if isGeminiModel(reqData.Model) {
+		resp, err = llm.NewNatsGeminiLLM(nc, reqData.Model).ChatWithCandidates(r.Context(), chatReq)
+	} else if reqData.N != nil && *reqData.N > 1 {
+		err = errors.New("n > 1 is only supported by Gemini models")
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	} else {
+		resp.ChatResponse, err = llm.NewNatsOllamaLLM(nc, reqData.Model).Chat(r.Context(), chatReq)
+	}
+	if err != nil {
+		log.Error("Error sending chat request:", err)
+		writeOpenAIError(w, httpStatus(err), err)
+		return
+	}
+
+	completion := createOpenAIChatResponse(reqData.Model, resp)
+	if !reqData.Stream {
+		writeJSON(w, http.StatusOK, completion)
+		return
+	}
+	includeUsage := reqData.StreamOptions != nil && reqData.StreamOptions.IncludeUsage
+	writeOpenAIStream(w, completion, includeUsage)
+}
+
+// createOllamaChatRequest translates an OpenAI chat completion request to an Ollama chat request.
+func createOllamaChatRequest(reqData openAIChatRequest) (*api.ChatRequest, error) {
+	messages, err := createOllamaMessages(reqData.Messages)
+	if err != nil {
+		return nil, err
+	}
+	chatReq := &api.ChatRequest{
+		Model:    reqData.Model,
+		Messages: messages,
+		Options:  map[string]any{},
+	}
+
+	for _, tool := range reqData.Tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("unsupported tool type '%s'", tool.Type)
+		}
+		var parameters api.ToolFunctionParameters
+		if len(tool.Function.Parameters) > 0 {
+			err = json.Unmarshal(tool.Function.Parameters, &parameters)
+			if err != nil {
+				return nil, fmt.Errorf("invalid parameters of tool '%s': %w", tool.Function.Name, err)
+			}
+		}
+		chatReq.Tools = append(chatReq.Tools, api.Tool{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name:        tool.Function.Name,
+				Description: tool.Function.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+
+	if reqData.ResponseFormat != nil {
+		switch reqData.ResponseFormat.Type {
+		case "json_object":
+			chatReq.Format = json.RawMessage(`"json"`)
+		case "json_schema":
+			if reqData.ResponseFormat.JSONSchema == nil {
+				return nil, errors.New("response format 'json_schema' without schema")
+			}
+			chatReq.Format = reqData.ResponseFormat.JSONSchema.Schema
+		case "text":
+		default:
+			return nil, fmt.Errorf("unsupported response format '%s'", reqData.ResponseFormat.Type)
+		}
+	}
+
+	setOption(chatReq.Options, "temperature", reqData.Temperature)
+	setOption(chatReq.Options, "top_p", reqData.TopP)
+	setOption(chatReq.Options, "seed", reqData.Seed)
+	setOption(chatReq.Options, "frequency_penalty", reqData.FrequencyPenalty)
+	setOption(chatReq.Options, "presence_penalty", reqData.PresencePenalty)
+	setOption(chatReq.Options, "num_predict", reqData.MaxTokens)
+	setOption(chatReq.Options, "num_predict", reqData.MaxCompletionTokens)
+	if reqData.N != nil && *reqData.N > 1 {
+		chatReq.Options["candidate_count"] = *reqData.N
+	}
+	if len(reqData.Stop) > 0 {
+		stop, err := stringOrList(reqData.Stop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stop sequences: %w", err)
+		}
+		chatReq.Options["stop"] = stop
+	}
+	return chatReq, nil
+}
+
+func setOption[T any](options map[string]any, name string, value *T) {
+	if value != nil {
+		options[name] = *value
+	}
+}
+
+// createOllamaMessages translates the messages of an OpenAI chat. The tool calls of the assistant are
+// referenced by ID in the tool results, which are matched to the name of the called tool.
+func createOllamaMessages(openAIMessages []openAIMessage) ([]api.Message, error) {
+	messages := []api.Message{}
+	toolNames := map[string]string{}
+	for _, openAIMessage := range openAIMessages {
+		content, images, err := parseOpenAIContent(openAIMessage.Content)
+		if err != nil {
+			return nil, err
+		}
+		message := api.Message{Role: openAIMessage.Role, Content: content, Images: images}
+		if message.Role == "developer" {
+			message.Role = "system"
+		}
+
+		for _, toolCall := range openAIMessage.ToolCalls {
+			arguments := api.ToolCallFunctionArguments{}
+			if toolCall.Function.Arguments != "" {
+				err = json.Unmarshal([]byte(toolCall.Function.Arguments), &arguments)
+				if err != nil {
+					return nil, fmt.Errorf("invalid arguments of tool call '%s': %w", toolCall.ID, err)
+				}
+			}
+			toolNames[toolCall.ID] = toolCall.Function.Name
+			message.ToolCalls = append(message.ToolCalls, api.ToolCall{Function: api.ToolCallFunction{
+				Index:     len(message.ToolCalls),
+				Name:      toolCall.Function.Name,
+				Arguments: arguments,
+			}})
+		}
+		if message.Role == "tool" {
+			message.ToolName = toolNames[openAIMessage.ToolCallID]
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// parseOpenAIContent reads the text and images of a message content. Images must be sent as data URLs.
+func parseOpenAIContent(data json.RawMessage) (string, []api.ImageData, error) {
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return "", nil, nil
+	}
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		return text, nil, nil
+	}
+
+	var parts []openAIContentPart
+	err := json.Unmarshal(data, &parts)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid message content: %w", err)
+	}
+	texts := []string{}
+	var images []api.ImageData
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			if part.ImageURL == nil {
+				return "", nil, errors.New("image part without URL")
+			}
+			image, err := decodeDataURL(part.ImageURL.URL)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, image)
+		default:
+			return "", nil, fmt.Errorf("unsupported content part '%s'", part.Type)
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
+func decodeDataURL(url string) ([]byte, error) {
+	data, ok := strings.CutPrefix(url, "data:")
+	if !ok {
+		return nil, errors.New("images must be sent as base64 encoded data URLs")
+	}
+	_, encoded, ok := strings.Cut(data, ";base64,")
+	if !ok {
+		return nil, errors.New("images must be sent as base64 encoded data URLs")
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// stringOrList reads a JSON value which is either a single string or a list of strings.
+func stringOrList(data json.RawMessage) ([]string, error) {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		return []string{value}, nil
+	}
+	var values []string
+	err := json.Unmarshal(data, &values)
+	return values, err
+}
+
+// createOpenAIChatResponse translates a chat response, further Gemini candidates become further choices.
+func createOpenAIChatResponse(model string, resp llm.GeminiChatResponse) openAIChatResponse {
+	completion := openAIChatResponse{
+		ID:      "chatcmpl-" + nuid.Next(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openAIChoice{createOpenAIChoice(0, resp.Message, resp.DoneReason)},
+		Usage: &openAIUsage{
+			PromptTokens:     resp.PromptEvalCount,
+			CompletionTokens: resp.EvalCount,
+			TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+		},
+	}
+	for i, alternative := range resp.Alternatives {
+		completion.Choices = append(completion.Choices, createOpenAIChoice(i+1, alternative.Message, alternative.DoneReason))
+	}
+	return completion
+}
+
+func createOpenAIChoice(index int, message api.Message, doneReason string) openAIChoice {
+	responseMessage := &openAIResponseMessage{Role: "assistant", Content: message.Content}
+	for _, toolCall := range message.ToolCalls {
+		arguments, _ := json.Marshal(toolCall.Function.Arguments)
+		openAIToolCall := openAIToolCall{ID: "call_" + nuid.Next(), Type: "function"}
+		openAIToolCall.Function.Name = toolCall.Function.Name
+		openAIToolCall.Function.Arguments = string(arguments)
+		responseMessage.ToolCalls = append(responseMessage.ToolCalls, openAIToolCall)
+	}
+	finishReason := openAIFinishReason(message, doneReason)
+	return openAIChoice{Index: index, Message: responseMessage, FinishReason: &finishReason}
+}
+
+// openAIFinishReason maps the done reasons of Ollama and the finish reasons of Gemini.
+func openAIFinishReason(message api.Message, doneReason string) string {
+	if len(message.ToolCalls) > 0 {
+		return "tool_calls"
+	}
+	switch strings.ToLower(doneReason) {
+	case "length", "max_tokens":
+		return "length"
+	case "safety", "recitation", "blocklist", "prohibited_content", "spii", "image_safety":
+		return "content_filter"
+	}
+	return "stop"
+}
+
+// writeOpenAIStream sends a completion as server sent events. The proxies do not stream, so the whole
+// message of each choice is sent as a single chunk, followed by a chunk with its finish reason.
+func writeOpenAIStream(w http.ResponseWriter, completion openAIChatResponse, includeUsage bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	chunk := func(choices []openAIChoice, usage *openAIUsage) openAIChatResponse {
+		return openAIChatResponse{
+			ID:      completion.ID,
+			Object:  "chat.completion.chunk",
+			Created: completion.Created,
+			Model:   completion.Model,
+			Choices: choices,
+			Usage:   usage,
+		}
+	}
+
+	events := []openAIChatResponse{}
+	for _, choice := range completion.Choices {
+		delta := *choice.Message
+		delta.ToolCalls = slices.Clone(delta.ToolCalls)
+		for i := range delta.ToolCalls {
+			delta.ToolCalls[i].Index = &i
+		}
+		events = append(events, chunk([]openAIChoice{{Index: choice.Index, Delta: &delta}}, nil))
+	}
+	for _, choice := range completion.Choices {
+		events = append(events, chunk([]openAIChoice{{Index: choice.Index, Delta: &openAIResponseMessage{}, FinishReason: choice.FinishReason}}, nil))
+	}
+	if includeUsage {
+		events = append(events, chunk([]openAIChoice{}, completion.Usage))
+	}
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Error("Error marshalling chunk:", err)
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (g *Gateway) openAIEmbeddingsHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	var reqData openAIEmbeddingRequest
+	err := json.NewDecoder(r.Body).Decode(&reqData)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if isGeminiModel(reqData.Model) {
+		writeOpenAIError(w, http.StatusBadRequest, errGeminiEmbeddings)
+		return
+	}
+	input, err := stringOrList(reqData.Input)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, errors.New("input must be a string or a list of strings, token arrays are not supported"))
+		return
+	}
+	if reqData.EncodingFormat != "" && reqData.EncodingFormat != "float" && reqData.EncodingFormat != "base64" {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Errorf("unsupported encoding format '%s'", reqData.EncodingFormat))
+		return
+	}
+
+	resp, err := g.newLLM(nc, reqData.Model).Embed(r.Context(), &api.EmbedRequest{Input: input, Dimensions: reqData.Dimensions})
+	if err != nil {
+		log.Error("Error sending embed request:", err)
+		writeOpenAIError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, createOpenAIEmbeddingResponse(reqData.Model, reqData.EncodingFormat, resp))
+}
+
+func createOpenAIEmbeddingResponse(model string, encodingFormat string, resp api.EmbedResponse) openAIEmbeddingResponse {
+	embeddingResponse := openAIEmbeddingResponse{
+		Object: "list",
+		Data:   []openAIEmbedding{},
+		Model:  model,
+		Usage:  openAIUsage{PromptTokens: resp.PromptEvalCount, TotalTokens: resp.PromptEvalCount},
+	}
+	for i, embedding := range resp.Embeddings {
+		var value any = embedding
+		if encodingFormat == "base64" {
+			value = encodeEmbedding(embedding)
+		}
+		embeddingResponse.Data = append(embeddingResponse.Data, openAIEmbedding{Object: "embedding", Index: i, Embedding: value})
+	}
+	return embeddingResponse
+}
+
+// encodeEmbedding encodes an embedding as base64 string of little endian float32 values.
+func encodeEmbedding(embedding []float32) string {
+	data := make([]byte, 4*len(embedding))
+	for i, value := range embedding {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(value))
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func (g *Gateway) openAIModelsHandler(w http.ResponseWriter, r *http.Request, nc *nats.Conn) {
+	models := openAIModelList{Object: "list", Data: []openAIModel{}}
+	for _, model := range g.listModels(r.Context(), nc) {
+		ownedBy := "ollama"
+		if isGeminiModel(model.Name) {
+			ownedBy = "gemini"
+		}
+		var created int64
+		if !model.ModifiedAt.IsZero() {
+			created = model.ModifiedAt.Unix()
+		}
+		models.Data = append(models.Data, openAIModel{ID: model.Name, Object: "model", Created: created, OwnedBy: ownedBy})
+	}
+	writeJSON(w, http.StatusOK, models)
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateOllamaChatRequest(t *testing.T) {
+	image := base64.StdEncoding.EncodeToString([]byte("png"))
+	reqData := openAIChatRequest{}
+	err := json.Unmarshal([]byte(`{
+		"model": "gemma3:27b",
+		"messages": [
+			{"role": "developer", "content": "You are a weather bot."},
+			{"role": "user", "content": [{"type": "text", "text": "How warm is it here?"}, {"type": "image_url", "image_url": {"url": "data:image/png;base64,`+image+`"}}]},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_temperature", "arguments": "{\"city\":\"Bern\"}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "21 degrees"}
+		],
+		"tools": [{"type": "function", "function": {"name": "get_temperature", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}}],
+		"response_format": {"type": "json_object"},
+		"temperature": 0.2,
+		"max_tokens": 100,
+		"stop": "END"
+	}`), &reqData)
+	assert.NoError(t, err)
+
+	chatReq, err := createOllamaChatRequest(reqData)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.Message{
+		{Role: "system", Content: "You are a weather bot."},
+		{Role: "user", Content: "How warm is it here?", Images: []api.ImageData{[]byte("png")}},
+		{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_temperature", Arguments: api.ToolCallFunctionArguments{"city": "Bern"}}}}},
+		{Role: "tool", Content: "21 degrees", ToolName: "get_temperature"},
+	}, chatReq.Messages)
+	assert.Equal(t, "get_temperature", chatReq.Tools[0].Function.Name)
+	assert.Equal(t, api.PropertyType{"string"}, chatReq.Tools[0].Function.Parameters.Properties["city"].Type)
+	assert.Equal(t, json.RawMessage(`"json"`), chatReq.Format)
+	assert.Equal(t, map[string]any{"temperature": 0.2, "num_predict": 100, "stop": []string{"END"}}, chatReq.Options)
+}
+
+func TestCreateOllamaChatRequestInvalid(t *testing.T) {
+	tt := []struct {
+		testName string
+		inReq    string
+	}{
+		{testName: "image url", inReq: `{"messages": [{"role": "user", "content": [{"type": "image_url", "image_url": {"url": "https://example.com/image.png"}}]}]}`},
+		{testName: "audio", inReq: `{"messages": [{"role": "user", "content": [{"type": "input_audio"}]}]}`},
+		{testName: "tool call arguments", inReq: `{"messages": [{"role": "assistant", "tool_calls": [{"id": "1", "function": {"name": "f", "arguments": "{"}}]}]}`},
+		{testName: "response format", inReq: `{"messages": [], "response_format": {"type": "xml"}}`},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			var reqData openAIChatRequest
+			assert.NoError(t, json.Unmarshal([]byte(td.inReq), &reqData))
+			_, err := createOllamaChatRequest(reqData)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCreateOpenAIChatResponse(t *testing.T) {
+	resp := llm.GeminiChatResponse{
+		ChatResponse: api.ChatResponse{
+			Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_time", Arguments: api.ToolCallFunctionArguments{}}}}},
+			Metrics: api.Metrics{PromptEvalCount: 10, EvalCount: 5},
+		},
+		Alternatives: []llm.GeminiAlternative{{Message: api.Message{Role: "model", Content: "Sorry"}, DoneReason: "MAX_TOKENS"}},
+	}
+
+	completion := createOpenAIChatResponse("gemini-2.5-flash", resp)
+	assert.Equal(t, "chat.completion", completion.Object)
+	assert.Equal(t, &openAIUsage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, completion.Usage)
+	assert.Len(t, completion.Choices, 2)
+	assert.Equal(t, "tool_calls", *completion.Choices[0].FinishReason)
+	assert.Equal(t, "get_time", completion.Choices[0].Message.ToolCalls[0].Function.Name)
+	assert.Equal(t, "{}", completion.Choices[0].Message.ToolCalls[0].Function.Arguments)
+	assert.Equal(t, 1, completion.Choices[1].Index)
+	assert.Equal(t, "Sorry", completion.Choices[1].Message.Content)
+	assert.Equal(t, "length", *completion.Choices[1].FinishReason)
+}
+
+func TestWriteOpenAIStream(t *testing.T) {
+	completion := createOpenAIChatResponse("gemma3:27b", llm.GeminiChatResponse{
+		ChatResponse: api.ChatResponse{Message: api.Message{Role: "assistant", Content: "Hello"}, DoneReason: "stop"},
+	})
+	recorder := httptest.NewRecorder()
+	writeOpenAIStream(recorder, completion, true)
+
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	events := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n\n")
+	assert.Len(t, events, 4)
+	assert.Equal(t, "data: [DONE]", events[3])
+
+	var chunk openAIChatResponse
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events[0], "data: ")), &chunk))
+	assert.Equal(t, "chat.completion.chunk", chunk.Object)
+	assert.Equal(t, "Hello", chunk.Choices[0].Delta.Content)
+	assert.Nil(t, chunk.Choices[0].FinishReason)
+
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events[1], "data: ")), &chunk))
+	assert.Equal(t, "stop", *chunk.Choices[0].FinishReason)
+
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(events[2], "data: ")), &chunk))
+	assert.Empty(t, chunk.Choices)
+	assert.NotNil(t, chunk.Usage)
+}
+
+func TestCreateOpenAIEmbeddingResponse(t *testing.T) {
+	resp := api.EmbedResponse{Embeddings: [][]float32{{0.5, -1}}, PromptEvalCount: 3}
+
+	floats := createOpenAIEmbeddingResponse("nomic-embed-text", "float", resp)
+	assert.Equal(t, []float32{0.5, -1}, floats.Data[0].Embedding)
+	assert.Equal(t, 3, floats.Usage.TotalTokens)
+
+	encoded := createOpenAIEmbeddingResponse("nomic-embed-text", "base64", resp)
+	data, err := base64.StdEncoding.DecodeString(encoded.Data[0].Embedding.(string))
+	assert.NoError(t, err)
+	assert.Equal(t, float32(-1), math.Float32frombits(binary.LittleEndian.Uint32(data[4:])))
+}
+
+func TestOpenAIEmbeddingsGeminiModel(t *testing.T) {
+	gateway := NewGateway("", nil, GatewayConfig{})
+	req := httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model": "gemini-embedding-001", "input": "Hello"}`))
+	recorder := httptest.NewRecorder()
+
+	gateway.OpenAIHandler().ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errGeminiEmbeddings.Error())
+}
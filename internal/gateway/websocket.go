@@ -0,0 +1,294 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFrameSize limits the size of a frame sent by a client, which may contain images.
+	maxFrameSize = 32 * 1024 * 1024
+
+	// maxWebSocketRequests limits the number of chat requests running concurrently on a connection.
+	maxWebSocketRequests = 8
+
+	// statusCancelled is the status of the error frame answering a request cancelled by the client.
+	statusCancelled = 499
+
+	websocketPingInterval = 30 * time.Second
+	websocketPongTimeout  = 60 * time.Second
+)
+
+var (
+	errRequestRunning  = errors.New("request is already running")
+	errTooManyRequests = fmt.Errorf("too many running requests, at most %d are allowed per connection", maxWebSocketRequests)
+)
+
+// Frame types of the WebSocket protocol.
+const (
+	FrameChat   = "chat"
+	FrameCancel = "cancel"
+	FrameChunk  = "chunk"
+	FrameDone   = "done"
+	FrameError  = "error"
+)
+
+// WebSocketFrame is a JSON frame of the WebSocket protocol. Clients send "chat" frames with a chat request
+// and an ID of their choice, and "cancel" frames with the ID of a running request. The gateway answers
+// each chat request with "chunk" frames followed by a "done" frame, or with an "error" frame. A cancelled
+// request is answered with an "error" frame with status 499.
+type WebSocketFrame struct {
+	ID        string            `json:"id"`
+	Type      string            `json:"type"`
+	SessionID string            `json:"session_id,omitempty"`
+	Request   *api.ChatRequest  `json:"request,omitempty"`
+	Response  *api.ChatResponse `json:"response,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Status    int               `json:"status,omitempty"`
+}
+
+func StartWebSocketGateway(natsUrl string, listen string, config GatewayConfig) error {
+	nc, err := nats.Connect(natsUrl, nats.Name("nats-llm-gateway"))
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	gateway := NewGateway(natsUrl, nc, config)
+	defer gateway.Close()
+	log.Infof("Starting WebSocket gateway on '%s'...", listen)
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws", gateway.WebSocketHandler())
+	return newServer(listen, mux).ListenAndServe()
+}
+
+// WebSocketHandler accepts WebSocket connections of browser clients. Browsers cannot set headers on
+// WebSocket connections, so the API key is sent as "api_key" query parameter or as bearer token. All
+// chat requests of a connection are sent with the NATS identity of its key and cancelled when the
+// connection is closed.
+func (g *Gateway) WebSocketHandler() http.Handler {
+	upgrader := websocket.Upgrader{CheckOrigin: g.checkOrigin}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey := r.URL.Query().Get("api_key")
+		if apiKey == "" {
+			nc, err := g.conn(r)
+			g.serveWebSocket(upgrader, w, r, nc, err)
+			return
+		}
+		nc, err := g.connForAPIKey(apiKey)
+		g.serveWebSocket(upgrader, w, r, nc, err)
+	})
+}
+
+// checkOrigin allows connections from pages of the gateway itself and of the allowed origins.
+func (g *Gateway) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(g.config.AllowedOrigins, "*") || slices.Contains(g.config.AllowedOrigins, origin) {
+		return true
+	}
+	originUrl, err := url.Parse(origin)
+	return err == nil && originUrl.Host == r.Host
+}
+
+func (g *Gateway) serveWebSocket(upgrader websocket.Upgrader, w http.ResponseWriter, r *http.Request, nc *nats.Conn, err error) {
+	if errors.Is(err, errUnauthorized) {
+		log.Warningf("Rejected unauthorized request on '%s'", r.URL.Path)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Error("Error connecting to NATS:", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request:
+		log.Warningf("Cannot upgrade WebSocket connection: %v", err)
+		return
+	}
+	newWebSocketSession(g, conn, nc).serve()
+}
+
+// webSocketSession holds the state of a single WebSocket connection.
+type webSocketSession struct {
+	gateway *Gateway
+	conn    *websocket.Conn
+	nc      *nats.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newWebSocketSession(gateway *Gateway, conn *websocket.Conn, nc *nats.Conn) *webSocketSession {
+	return &webSocketSession{
+		gateway: gateway,
+		conn:    conn,
+		nc:      nc,
+		cancels: map[string]context.CancelFunc{},
+	}
+}
+
+// serve reads the frames of the client until the connection is closed, which cancels all running requests.
+func (s *webSocketSession) serve() {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		s.conn.Close()
+	}()
+
+	s.conn.SetReadLimit(maxFrameSize)
+	s.conn.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	})
+	go s.ping(ctx)
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Warningf("WebSocket connection closed: %v", err)
+			}
+			return
+		}
+		var frame WebSocketFrame
+		err = json.Unmarshal(data, &frame)
+		if err != nil {
+			s.write(WebSocketFrame{Type: FrameError, Error: err.Error(), Status: http.StatusBadRequest})
+			continue
+		}
+
+		switch frame.Type {
+		case FrameChat:
+			requestCtx, err := s.start(ctx, frame.ID)
+			if err != nil {
+				status := http.StatusTooManyRequests
+				if errors.Is(err, errRequestRunning) {
+					status = http.StatusConflict
+				}
+				s.write(WebSocketFrame{ID: frame.ID, Type: FrameError, Error: err.Error(), Status: status})
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer s.finish(frame.ID)
+				s.chat(ctx, requestCtx, frame)
+			}()
+		case FrameCancel:
+			s.cancel(frame.ID)
+		default:
+			s.write(WebSocketFrame{ID: frame.ID, Type: FrameError, Error: fmt.Sprintf("unknown frame type '%s'", frame.Type), Status: http.StatusBadRequest})
+		}
+	}
+}
+
+// chat sends a chat request within the context of the request, which is derived from the context of the
+// connection.
+func (s *webSocketSession) chat(connCtx context.Context, ctx context.Context, frame WebSocketFrame) {
+	if frame.Request == nil {
+		s.write(WebSocketFrame{ID: frame.ID, Type: FrameError, Error: "chat frame without request", Status: http.StatusBadRequest})
+		return
+	}
+	if frame.SessionID != "" {
+		ctx = llm.ContextWithSession(ctx, frame.SessionID)
+	}
+
+	resp, err := s.gateway.newLLM(s.nc, frame.Request.Model).Chat(ctx, frame.Request)
+	if connCtx.Err() != nil {
+		// The connection was closed, nobody waits for the response:
+		return
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		s.write(WebSocketFrame{ID: frame.ID, Type: FrameError, Error: "cancelled", Status: statusCancelled})
+		return
+	}
+	if err != nil {
+		log.Error("Error sending chat request:", err)
+		s.write(WebSocketFrame{ID: frame.ID, Type: FrameError, Error: err.Error(), Status: httpStatus(err)})
+		return
+	}
+
+	chunks := chatChunks(resp)
+	s.write(WebSocketFrame{ID: frame.ID, Type: FrameChunk, Response: &chunks[0]})
+	s.write(WebSocketFrame{ID: frame.ID, Type: FrameDone, Response: &chunks[1]})
+}
+
+// start registers a running request, which times out after the request timeout of the gateway.
+func (s *webSocketSession) start(ctx context.Context, id string) (context.Context, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cancels[id]; ok {
+		return nil, fmt.Errorf("%w: '%s'", errRequestRunning, id)
+	}
+	if len(s.cancels) >= maxWebSocketRequests {
+		return nil, errTooManyRequests
+	}
+	requestCtx, cancel := context.WithTimeout(ctx, s.gateway.config.RequestTimeout)
+	s.cancels[id] = cancel
+	return requestCtx, nil
+}
+
+func (s *webSocketSession) finish(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+}
+
+func (s *webSocketSession) cancel(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+	}
+}
+
+// ping keeps the connection alive and detects clients which went away without closing it.
+func (s *webSocketSession) ping(ctx context.Context) {
+	ticker := time.NewTicker(websocketPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.writeMu.Lock()
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketPingInterval))
+			s.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// write sends a frame, the connection does not support concurrent writers.
+func (s *webSocketSession) write(frame WebSocketFrame) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	err := s.conn.WriteJSON(frame)
+	if err != nil {
+		log.Warningf("Cannot send WebSocket frame: %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubLLM answers chat requests with the content of the last message. Requests for the model "block"
+// wait until they are cancelled, which is reported on the cancelled channel.
+type stubLLM struct {
+	started   chan struct{}
+	cancelled chan error
+}
+
+func newStubLLM() *stubLLM {
+	return &stubLLM{started: make(chan struct{}, maxWebSocketRequests+1), cancelled: make(chan error, maxWebSocketRequests+1)}
+}
+
+func (s *stubLLM) gateway() *Gateway {
+	gateway := NewGateway("", nil, GatewayConfig{})
+	gateway.newLLM = func(nc *nats.Conn, model string) llm.LLM {
+		return s
+	}
+	return gateway
+}
+
+func (s *stubLLM) Chat(ctx context.Context, req *api.ChatRequest) (api.ChatResponse, error) {
+	if req.Model == "block" {
+		s.started <- struct{}{}
+		<-ctx.Done()
+		s.cancelled <- ctx.Err()
+		return api.ChatResponse{}, ctx.Err()
+	}
+	message := req.Messages[len(req.Messages)-1]
+	return api.ChatResponse{Model: req.Model, Message: api.Message{Role: "assistant", Content: message.Content}, Done: true, DoneReason: "stop"}, nil
+}
+
+func (s *stubLLM) Embed(ctx context.Context, req *api.EmbedRequest) (api.EmbedResponse, error) {
+	return api.EmbedResponse{}, nil
+}
+
+func (s *stubLLM) Show(ctx context.Context, req *api.ShowRequest) (api.ShowResponse, error) {
+	return api.ShowResponse{}, nil
+}
+
+func chatFrame(id string, model string) WebSocketFrame {
+	return WebSocketFrame{ID: id, Type: FrameChat, Request: &api.ChatRequest{
+		Model:    model,
+		Messages: []api.Message{{Role: "user", Content: "Hello"}},
+	}}
+}
+
+func waitFor[T any](t *testing.T, ch chan T) T {
+	select {
+	case value := <-ch:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	var zero T
+	return zero
+}
+
+func dialWebSocket(t *testing.T, gateway *Gateway, query string) (*websocket.Conn, *http.Response, error) {
+	server := httptest.NewServer(gateway.WebSocketHandler())
+	t.Cleanup(server.Close)
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws"+query, nil)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestWebSocketAuthentication(t *testing.T) {
+	gateway := NewGateway("", nil, GatewayConfig{APIKeys: map[string]NatsIdentity{"sk-valid": {}}})
+
+	_, resp, err := dialWebSocket(t, gateway, "?api_key=sk-invalid")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	_, _, err = dialWebSocket(t, gateway, "?api_key=sk-valid")
+	assert.NoError(t, err)
+}
+
+func TestWebSocketInvalidFrames(t *testing.T) {
+	conn, _, err := dialWebSocket(t, NewGateway("", nil, GatewayConfig{}), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tt := []struct {
+		testName      string
+		inFrame       string
+		expectedFrame WebSocketFrame
+	}{
+		{testName: "invalid json", inFrame: `{"id": `, expectedFrame: WebSocketFrame{Type: FrameError, Status: http.StatusBadRequest}},
+		{testName: "unknown type", inFrame: `{"id": "1", "type": "generate"}`, expectedFrame: WebSocketFrame{ID: "1", Type: FrameError, Status: http.StatusBadRequest}},
+		{testName: "missing request", inFrame: `{"id": "2", "type": "chat"}`, expectedFrame: WebSocketFrame{ID: "2", Type: FrameError, Status: http.StatusBadRequest}},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			assert.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(td.inFrame)))
+			var frame WebSocketFrame
+			assert.NoError(t, conn.ReadJSON(&frame))
+			assert.NotEmpty(t, frame.Error)
+			frame.Error = ""
+			assert.Equal(t, td.expectedFrame, frame)
+		})
+	}
+}
+
+func TestWebSocketChat(t *testing.T) {
+	conn, _, err := dialWebSocket(t, newStubLLM().gateway(), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, conn.WriteJSON(chatFrame("1", "gemma3:27b")))
+
+	var chunk, done WebSocketFrame
+	assert.NoError(t, conn.ReadJSON(&chunk))
+	assert.NoError(t, conn.ReadJSON(&done))
+	assert.Equal(t, FrameChunk, chunk.Type)
+	assert.Equal(t, "1", chunk.ID)
+	assert.Equal(t, "Hello", chunk.Response.Message.Content)
+	assert.False(t, chunk.Response.Done)
+	assert.Equal(t, FrameDone, done.Type)
+	assert.Equal(t, "1", done.ID)
+	assert.True(t, done.Response.Done)
+	assert.Equal(t, "stop", done.Response.DoneReason)
+}
+
+func TestWebSocketCancel(t *testing.T) {
+	stub := newStubLLM()
+	conn, _, err := dialWebSocket(t, stub.gateway(), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, conn.WriteJSON(chatFrame("1", "block")))
+	waitFor(t, stub.started)
+	assert.NoError(t, conn.WriteJSON(WebSocketFrame{ID: "1", Type: FrameCancel}))
+
+	var frame WebSocketFrame
+	assert.NoError(t, conn.ReadJSON(&frame))
+	assert.Equal(t, WebSocketFrame{ID: "1", Type: FrameError, Error: "cancelled", Status: statusCancelled}, frame)
+	assert.ErrorIs(t, waitFor(t, stub.cancelled), context.Canceled)
+}
+
+func TestWebSocketCloseCancelsRequests(t *testing.T) {
+	stub := newStubLLM()
+	conn, _, err := dialWebSocket(t, stub.gateway(), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, conn.WriteJSON(chatFrame("1", "block")))
+	waitFor(t, stub.started)
+	conn.Close()
+
+	assert.ErrorIs(t, waitFor(t, stub.cancelled), context.Canceled)
+}
+
+func TestWebSocketTooManyRequests(t *testing.T) {
+	stub := newStubLLM()
+	conn, _, err := dialWebSocket(t, stub.gateway(), "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for i := 0; i < maxWebSocketRequests; i++ {
+		assert.NoError(t, conn.WriteJSON(chatFrame(strconv.Itoa(i), "block")))
+		waitFor(t, stub.started)
+	}
+	assert.NoError(t, conn.WriteJSON(chatFrame("0", "block")))
+	assert.NoError(t, conn.WriteJSON(chatFrame("too-many", "block")))
+
+	var running, tooMany WebSocketFrame
+	assert.NoError(t, conn.ReadJSON(&running))
+	assert.NoError(t, conn.ReadJSON(&tooMany))
+	assert.Equal(t, http.StatusConflict, running.Status)
+	assert.Equal(t, "too-many", tooMany.ID)
+	assert.Equal(t, http.StatusTooManyRequests, tooMany.Status)
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tt := []struct {
+		testName       string
+		inOrigin       string
+		inAllowed      []string
+		expectedResult bool
+	}{
+		{testName: "no origin", expectedResult: true},
+		{testName: "same origin", inOrigin: "http://gateway:8080", expectedResult: true},
+		{testName: "other origin", inOrigin: "https://app.example.com", expectedResult: false},
+		{testName: "allowed origin", inOrigin: "https://app.example.com", inAllowed: []string{"https://app.example.com"}, expectedResult: true},
+		{testName: "all origins", inOrigin: "https://app.example.com", inAllowed: []string{"*"}, expectedResult: true},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			gateway := NewGateway("", nil, GatewayConfig{AllowedOrigins: td.inAllowed})
+			req := httptest.NewRequest(http.MethodGet, "http://gateway:8080/ws", nil)
+			if td.inOrigin != "" {
+				req.Header.Set("Origin", td.inOrigin)
+			}
+			assert.Equal(t, td.expectedResult, gateway.checkOrigin(req))
+		})
+	}
+}
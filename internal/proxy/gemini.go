@@ -1,20 +1,25 @@
 package proxy
 
 import (
+	"cloud.google.com/go/auth/credentials"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/charmbracelet/huh/spinner"
+	"github.com/hofer/nats-llm/pkq/llm"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 	"github.com/ollama/ollama/api"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/genai"
+	"net/http"
 	"runtime"
+	"time"
 )
 
-func StartNatsGeminiProxy(nc *nats.Conn, apiKey string) error {
-	natsGeminiProxy := NewNatsGeminiProxy(apiKey)
+func StartNatsGeminiProxy(nc *nats.Conn, config GeminiProxyConfig) error {
+	natsGeminiProxy := NewNatsGeminiProxy(config)
 	err := natsGeminiProxy.Start(nc)
 	if err != nil {
 		return err
@@ -24,33 +29,105 @@ func StartNatsGeminiProxy(nc *nats.Conn, apiKey string) error {
 	return nil
 }
 
+// Backends of the Gemini proxy.
+const (
+	GeminiBackendAPI    = "gemini"
+	GeminiBackendVertex = "vertex"
+)
+
+// GeminiProxyConfig holds the settings of a NatsGeminiProxy.
+type GeminiProxyConfig struct {
+	APIKey string
+
+	// Backend is either GeminiBackendAPI (default) or GeminiBackendVertex.
+	Backend string
+
+	// Project and Location of Vertex AI, only used by GeminiBackendVertex.
+	Project  string
+	Location string
+
+	// CredentialsFile is the service account key used with GeminiBackendVertex. Without a file the
+	// application default credentials are used.
+	CredentialsFile string
+
+	// Models restricts the models clients may use. A nil filter allows all models.
+	Models *ModelFilter
+
+	// ModelListRefresh is the interval at which the cached list of Gemini models is reloaded.
+	ModelListRefresh time.Duration
+
+	// SafetySettings are the default safety settings of all chat requests. Requests can override them
+	// per harm category with the SafetySettingsHeader.
+	SafetySettings []*genai.SafetySetting
+
+	// CacheTTL is the lifetime of the Gemini cached contents created for large prompt prefixes. Zero
+	// disables context caching.
+	CacheTTL time.Duration
+
+	// CacheMinTokens is the estimated number of tokens from which a prompt prefix is cached.
+	CacheMinTokens int
+
+	// Sessions enables chat sessions stored in NATS KV, which expire after SessionTTL without updates.
+	Sessions   bool
+	SessionTTL time.Duration
+
+	// AdminToken must be sent in the AdminTokenHeader to list sessions. This endpoint is disabled if no
+	// token is set.
+	AdminToken string
+
+	// SummaryModel summarizes the history of chat requests using the "summarize" truncation strategy.
+	// Without a summary model the model of the request is used.
+	SummaryModel string
+}
+
 type NatsGeminiProxy struct {
-	apiKey string
-	client *genai.Client
+	config   GeminiProxyConfig
+	client   *genai.Client
+	models   geminiModelCache
+	cache    *geminiContextCache
+	sessions *sessionStore
+	metrics  handlerMetrics
 }
 
-func NewNatsGeminiProxy(apiKey string) *NatsGeminiProxy {
+func NewNatsGeminiProxy(config GeminiProxyConfig) *NatsGeminiProxy {
 	return &NatsGeminiProxy{
-		apiKey: apiKey,
+		config: config,
 	}
 }
 
 func (n *NatsGeminiProxy) Start(nc *nats.Conn) error {
 	ctx := context.Background()
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey: n.apiKey,
-	})
-
+	clientConfig, err := createGeminiClientConfig(n.config)
+	if err != nil {
+		return err
+	}
+	client, err := genai.NewClient(ctx, clientConfig)
 	if err != nil {
 		return err
 	}
 	n.client = client
 	//defer client.Close()
 
+	err = n.models.refresh(ctx, client)
+	if err != nil {
+		log.Warningf("Cannot load the list of Gemini models: %v", err)
+	}
+	if n.config.ModelListRefresh > 0 {
+		go n.models.refreshPeriodically(ctx, client, n.config.ModelListRefresh)
+	}
+
+	if n.config.CacheTTL > 0 {
+		n.cache, err = newGeminiContextCache(ctx, nc, client, n.config.CacheTTL, n.config.CacheMinTokens)
+		if err != nil {
+			log.Warningf("Context caching is disabled: %v", err)
+		}
+	}
+
 	srv, err := micro.AddService(nc, micro.Config{
-		Name:        "NatsGemini",
-		Version:     "0.0.1",
-		Description: "Nats microservice acting as a proxy for Gemini.",
+		Name:         "NatsGemini",
+		Version:      "0.0.1",
+		Description:  "Nats microservice acting as a proxy for Gemini.",
+		StatsHandler: n.metrics.stats,
 	})
 	if err != nil {
 		return err
@@ -64,7 +141,7 @@ func (n *NatsGeminiProxy) Start(nc *nats.Conn) error {
 	if err != nil {
 		return err
 	}
-	err = root.AddEndpoint("chat", micro.HandlerFunc(n.chatHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("chat", n.metrics.recoverHandler(n.chatHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": chatSchema,
 	}))
 	if err != nil {
@@ -76,13 +153,76 @@ func (n *NatsGeminiProxy) Start(nc *nats.Conn) error {
 	if err != nil {
 		return err
 	}
-	err = root.AddEndpoint("show", micro.HandlerFunc(n.showHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("show", n.metrics.recoverHandler(n.showHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": showSchema,
 	}))
+	if err != nil {
+		return err
+	}
+
+	// List
+	listSchema, err := GetGeminiSchemaList()
+	if err != nil {
+		return err
+	}
+	err = root.AddEndpoint("list", n.metrics.recoverHandler(n.listHandler), micro.WithEndpointMetadata(map[string]string{
+		"schema": listSchema,
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Count tokens
+	countTokensSchema, err := GetGeminiSchemaCountTokens()
+	if err != nil {
+		return err
+	}
+	err = root.AddEndpoint("count_tokens", n.metrics.recoverHandler(n.countTokensHandler), micro.WithEndpointMetadata(map[string]string{
+		"schema": countTokensSchema,
+	}))
+	if err != nil {
+		return err
+	}
 
+	// Sessions
+	if n.config.Sessions {
+		n.sessions, err = startSessions(ctx, nc, srv, n.config.SessionTTL, n.config.AdminToken, &n.metrics)
+	}
 	return err
 }
 
+// createGeminiClientConfig creates the genai client config for the backend selected in the proxy config.
+func createGeminiClientConfig(config GeminiProxyConfig) (*genai.ClientConfig, error) {
+	switch config.Backend {
+	case "", GeminiBackendAPI:
+		return &genai.ClientConfig{
+			Backend: genai.BackendGeminiAPI,
+			APIKey:  config.APIKey,
+		}, nil
+	case GeminiBackendVertex:
+		if config.Project == "" || config.Location == "" {
+			return nil, fmt.Errorf("the Vertex AI backend requires a project and a location")
+		}
+		clientConfig := &genai.ClientConfig{
+			Backend:  genai.BackendVertexAI,
+			Project:  config.Project,
+			Location: config.Location,
+		}
+		if config.CredentialsFile != "" {
+			creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+				Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+				CredentialsFile: config.CredentialsFile,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("cannot load the credentials '%s': %w", config.CredentialsFile, err)
+			}
+			clientConfig.Credentials = creds
+		}
+		return clientConfig, nil
+	}
+	return nil, fmt.Errorf("unknown Gemini backend '%s', expecting '%s' or '%s'", config.Backend, GeminiBackendAPI, GeminiBackendVertex)
+}
+
 func (n *NatsGeminiProxy) chatHandler(req micro.Request) {
 	var reqData api.ChatRequest
 	err := json.Unmarshal(req.Data(), &reqData)
@@ -91,22 +231,71 @@ func (n *NatsGeminiProxy) chatHandler(req micro.Request) {
 		return
 	}
 
-	// Create the chat session with the Gemini model:
-	history := createHistoryContent(reqData)
-	chat, err := n.client.Chats.Create(context.Background(), reqData.Model, &genai.GenerateContentConfig{
-		Tools:             createGeminiToolSchema(reqData),
-		SystemInstruction: createGeminiSystemPrompt(reqData),
-	}, history)
+	session, err := n.sessions.prepareChat(context.Background(), req, &reqData)
 	if err != nil {
-		req.Error("500", err.Error(), nil)
+		req.Error(sessionErrorCode(err), err.Error(), nil)
+		return
+	}
+
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
 		return
 	}
 
-	// User content can either be a user input or a tool response:
-	userContentParts, contentErr := createUserContentParts(reqData)
-	if contentErr != nil {
-		log.Errorf("session.SendMessage: %v", contentErr)
-		req.Error("500", contentErr.Error(), nil)
+	strategy, err := parseTruncationStrategy(req.Headers().Get(TruncateHeader))
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+	responseHeaders := micro.Headers{}
+	if strategy != nil {
+		budget, err := n.inputTokenLimit(context.Background(), reqData.Model)
+		if err != nil {
+			req.Error("500", err.Error(), nil)
+			return
+		}
+		truncated, err := truncateChat(context.Background(), &reqData, strategy, budget, n.summarizer(reqData.Model))
+		if err != nil {
+			req.Error("500", err.Error(), nil)
+			return
+		}
+		if truncated != nil {
+			log.Infof("Chat request for model '%s': truncated the history (%s)", reqData.Model, truncated)
+			responseHeaders[TruncatedHeader] = []string{truncated.String()}
+		}
+	}
+
+	config, warnings, err := createGeminiGenerateConfig(reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+	for _, warning := range warnings {
+		log.Warningf("Chat request for model '%s': %s", reqData.Model, warning)
+	}
+
+	safetySettings, err := requestSafetySettings(req.Headers().Get(SafetySettingsHeader))
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+	config.SafetySettings = mergeGeminiSafetySettings(n.config.SafetySettings, safetySettings)
+
+	// User content can either be a user input or tool responses:
+	history, userContentParts, err := createChatContents(reqData)
+	if err != nil {
+		log.Errorf("cannot translate the messages: %v", err)
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	// Large system prompts and documents leading the history are moved into a cached content:
+	history = n.cache.apply(context.Background(), reqData.Model, config, history)
+
+	// Create the chat session with the Gemini model:
+	chat, err := n.client.Chats.Create(context.Background(), reqData.Model, config, history)
+	if err != nil {
+		req.Error("500", err.Error(), nil)
 		return
 	}
 
@@ -116,7 +305,7 @@ func (n *NatsGeminiProxy) chatHandler(req micro.Request) {
 		res, err = chat.Send(context.Background(), userContentParts...)
 	}
 
-	sp.Title(fmt.Sprintf("Generate content with model '%s'...", reqData.Model)).Action(action).Run()
+	runSpinner(sp.Title(fmt.Sprintf("Generate content with model '%s'...", reqData.Model)), action)
 	if err != nil {
 		log.Errorf("session.SendMessage: %v", err)
 		req.Error("500", err.Error(), nil)
@@ -124,9 +313,23 @@ func (n *NatsGeminiProxy) chatHandler(req micro.Request) {
 	}
 
 	ollamaResp, err := createOllamaChatResponse(res)
+	var blocked *blockedError
+	if errors.As(err, &blocked) {
+		log.Warningf("Chat request for model '%s': %v", reqData.Model, err)
+		feedback, _ := json.Marshal(blocked.feedback)
+		req.Error("422", err.Error(), feedback)
+		return
+	}
 	if err != nil {
 		log.Errorf("cannot create a response: %v", err)
-		req.Error("400", err.Error(), nil)
+		req.Error("502", err.Error(), nil)
+		return
+	}
+
+	err = session.save(context.Background(), ollamaResp.Message)
+	if err != nil {
+		log.Errorf("cannot save the session: %v", err)
+		req.Error("500", fmt.Sprintf("cannot save the session: %v", err), nil)
 		return
 	}
 
@@ -138,7 +341,66 @@ func (n *NatsGeminiProxy) chatHandler(req micro.Request) {
 	}
 
 	log.Debug(string(responseData))
-	err = req.Respond(responseData)
+	responseHeaders[WarningsHeader] = warnings
+	err = req.Respond(responseData, micro.WithHeaders(responseHeaders))
+}
+
+func (n *NatsGeminiProxy) countTokensHandler(req micro.Request) {
+	var reqData api.ChatRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	contents, err := createContents(reqData.Messages)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+	tools, err := createGeminiToolSchema(reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	backend := n.client.ClientConfig().Backend
+	contents, countConfig, estimatedTokens := createGeminiCountTokensRequest(backend, createGeminiSystemPrompt(reqData), tools, contents)
+	resp, err := n.client.Models.CountTokens(context.Background(), reqData.Model, contents, countConfig)
+	if err != nil {
+		log.Errorf("cannot count tokens: %v", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+
+	respondJSON(req, llm.CountTokensResponse{
+		Model:       reqData.Model,
+		TotalTokens: int(resp.TotalTokens) + estimatedTokens,
+		Estimated:   estimatedTokens > 0,
+	})
+}
+
+// createGeminiCountTokensRequest prepares counting the tokens of a chat request. The Gemini API cannot count
+// system instructions and tools, the system instruction is therefore counted as user content and the tokens
+// of the tools are returned as estimate.
+func createGeminiCountTokensRequest(backend genai.Backend, systemPrompt *genai.Content, tools []*genai.Tool, contents []*genai.Content) ([]*genai.Content, *genai.CountTokensConfig, int) {
+	if backend == genai.BackendVertexAI {
+		return contents, &genai.CountTokensConfig{SystemInstruction: systemPrompt, Tools: tools}, 0
+	}
+
+	if systemPrompt != nil {
+		contents = append([]*genai.Content{{Role: genai.RoleUser, Parts: systemPrompt.Parts}}, contents...)
+	}
+	estimatedTokens := 0
+	if len(tools) > 0 {
+		estimatedTokens = jsonLength(tools) / 4
+	}
+	return contents, nil, estimatedTokens
 }
 
 func (n *NatsGeminiProxy) showHandler(req micro.Request) {
@@ -149,6 +411,11 @@ func (n *NatsGeminiProxy) showHandler(req micro.Request) {
 		return
 	}
 
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
 	// Get the generative model requested by the user:
 	model, err := n.client.Models.Get(context.Background(), reqData.Model, &genai.GetModelConfig{})
 	if err != nil {
@@ -157,7 +424,7 @@ func (n *NatsGeminiProxy) showHandler(req micro.Request) {
 		return
 	}
 
-	ollamaResp, err := createOllamaShowResponse(model)
+	ollamaResp, err := createOllamaShowResponse(model, n.modelDefaults(context.Background(), model.Name))
 	if err != nil {
 		log.Errorf("cannot create a response: %v", err)
 		req.Error("400", err.Error(), nil)
@@ -174,3 +441,112 @@ func (n *NatsGeminiProxy) showHandler(req micro.Request) {
 	log.Debug(string(responseData))
 	err = req.Respond(responseData)
 }
+
+func (n *NatsGeminiProxy) listHandler(req micro.Request) {
+	models, err := n.listModels(context.Background())
+	if err != nil {
+		log.Errorf("cannot list models: %v", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+
+	responseData, err := json.Marshal(createGeminiListResponse(models, n.config.Models))
+	if err != nil {
+		log.Errorf("cannot create a response: %v", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+
+	err = req.Respond(responseData)
+}
+
+// inputTokenLimit returns the maximum number of input tokens of a model.
+func (n *NatsGeminiProxy) inputTokenLimit(ctx context.Context, modelName string) (int, error) {
+	models, _ := n.models.get()
+	for _, model := range models {
+		if geminiModelName(model.Name) == geminiModelName(modelName) && model.InputTokenLimit > 0 {
+			return int(model.InputTokenLimit), nil
+		}
+	}
+
+	model, err := n.client.Models.Get(ctx, modelName, &genai.GetModelConfig{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot read the input token limit of model '%s': %w", modelName, err)
+	}
+	if model.InputTokenLimit <= 0 {
+		return 0, fmt.Errorf("the input token limit of model '%s' is unknown", modelName)
+	}
+	return int(model.InputTokenLimit), nil
+}
+
+// summarizer summarizes messages with the configured summary model or the given model of the request.
+func (n *NatsGeminiProxy) summarizer(model string) summarizeFunc {
+	if n.config.SummaryModel != "" {
+		model = n.config.SummaryModel
+	}
+	return func(ctx context.Context, messages []api.Message) (string, error) {
+		resp, err := n.client.Models.GenerateContent(ctx, model, genai.Text(summaryTranscript(messages)), nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.Text(), nil
+	}
+}
+
+// modelDefaultsTimeout limits the time spent reading the default parameters of a model for a show request.
+const modelDefaultsTimeout = 10 * time.Second
+
+// modelDefaults returns the default generation parameters of a model, reading them from the Gemini REST
+// API on the first request. Errors are only logged, as these defaults are not essential for a show response.
+func (n *NatsGeminiProxy) modelDefaults(ctx context.Context, modelName string) *geminiModelDefaults {
+	if defaults, ok := n.models.getDefaults(modelName); ok {
+		return defaults
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, modelDefaultsTimeout)
+	defer cancel()
+	defaults, err := n.fetchModelDefaults(ctx, modelName)
+	if err != nil {
+		log.Warningf("Cannot read defaults of model '%s': %v", modelName, err)
+		return nil
+	}
+	n.models.putDefaults(modelName, defaults)
+	return defaults
+}
+
+// fetchModelDefaults reads the default generation parameters of a model from the Gemini REST API using the
+// HTTP client of the genai client. The Vertex AI backend does not provide them.
+func (n *NatsGeminiProxy) fetchModelDefaults(ctx context.Context, modelName string) (*geminiModelDefaults, error) {
+	clientConfig := n.client.ClientConfig()
+	if clientConfig.Backend == genai.BackendVertexAI {
+		return nil, nil
+	}
+
+	modelUrl := fmt.Sprintf("%s%s/%s", clientConfig.HTTPOptions.BaseURL, clientConfig.HTTPOptions.APIVersion, modelName)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, modelUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("x-goog-api-key", clientConfig.APIKey)
+
+	httpClient := clientConfig.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status '%s'", resp.Status)
+	}
+
+	var defaults geminiModelDefaults
+	err = json.NewDecoder(resp.Body).Decode(&defaults)
+	if err != nil {
+		return nil, err
+	}
+	return &defaults, nil
+}
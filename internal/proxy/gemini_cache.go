@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/genai"
+	"time"
+)
+
+// GeminiCacheBucket is the NATS KV bucket holding the names of the Gemini cached contents by content hash.
+const GeminiCacheBucket = "nats_llm_gemini_cache"
+
+// geminiCacheExpiryMargin is the time before its expiry from which a cached content is no longer used,
+// so it cannot expire between the lookup and the request to Gemini.
+const geminiCacheExpiryMargin = time.Minute
+
+type geminiCacheEntry struct {
+	Name       string    `json:"name"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+// geminiContextCache moves large, stable prompt prefixes (system instruction, tools and the leading user
+// contents of the history) into Gemini cached contents. The cached contents are shared between all proxy
+// instances via a NATS KV bucket, keyed by a hash of the prefix.
+type geminiContextCache struct {
+	client    *genai.Client
+	kv        jetstream.KeyValue
+	ttl       time.Duration
+	minTokens int
+	creates   singleflight.Group
+}
+
+func newGeminiContextCache(ctx context.Context, nc *nats.Conn, client *genai.Client, ttl time.Duration, minTokens int) (*geminiContextCache, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      GeminiCacheBucket,
+		Description: "Gemini cached contents of nats-llm",
+		TTL:         ttl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the KV bucket '%s': %w", GeminiCacheBucket, err)
+	}
+
+	return &geminiContextCache{
+		client:    client,
+		kv:        kv,
+		ttl:       ttl,
+		minTokens: minTokens,
+	}, nil
+}
+
+// apply uses a cached content for the prefix of the request if it is large enough. In that case the
+// system instruction and tools are removed from the config and the history is returned without the
+// cached contents. Caching errors are only logged, the request is then sent without a cached content.
+func (c *geminiContextCache) apply(ctx context.Context, model string, config *genai.GenerateContentConfig, history []*genai.Content) []*genai.Content {
+	if c == nil {
+		return history
+	}
+
+	prefix := cacheablePrefix(history)
+	if estimateTokens(append([]*genai.Content{config.SystemInstruction}, prefix...)...) < c.minTokens {
+		return history
+	}
+
+	cacheConfig := &genai.CreateCachedContentConfig{
+		TTL:               c.ttl,
+		Contents:          prefix,
+		SystemInstruction: config.SystemInstruction,
+		Tools:             config.Tools,
+		ToolConfig:        config.ToolConfig,
+	}
+	key, err := geminiCacheKey(model, cacheConfig)
+	if err != nil {
+		log.Warningf("Cannot cache the prompt of model '%s': %v", model, err)
+		return history
+	}
+
+	name, err := c.cachedContent(ctx, model, key, cacheConfig)
+	if err != nil {
+		log.Warningf("Cannot cache the prompt of model '%s': %v", model, err)
+		return history
+	}
+
+	// Gemini rejects requests setting these in addition to a cached content:
+	config.CachedContent = name
+	config.SystemInstruction = nil
+	config.Tools = nil
+	config.ToolConfig = nil
+	return history[len(prefix):]
+}
+
+// cachedContent returns the name of the cached content stored for the key or creates a new one.
+func (c *geminiContextCache) cachedContent(ctx context.Context, model string, key string, cacheConfig *genai.CreateCachedContentConfig) (string, error) {
+	entry, err := c.kv.Get(ctx, key)
+	if err == nil {
+		var cacheEntry geminiCacheEntry
+		err = json.Unmarshal(entry.Value(), &cacheEntry)
+		if err == nil && time.Until(cacheEntry.ExpireTime) > geminiCacheExpiryMargin {
+			return cacheEntry.Name, nil
+		}
+	} else if !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", err
+	}
+
+	// Concurrent requests with the same prefix all wait on a single cached content:
+	name, err, _ := c.creates.Do(key, func() (any, error) {
+		cacheConfig.DisplayName = "nats-llm-" + key[:16]
+		cached, err := c.client.Caches.Create(ctx, model, cacheConfig)
+		if err != nil {
+			return "", err
+		}
+		log.Infof("Created the Gemini cached content '%s' for model '%s'", cached.Name, model)
+
+		expireTime := cached.ExpireTime
+		if expireTime.IsZero() {
+			expireTime = time.Now().Add(c.ttl)
+		}
+		value, err := json.Marshal(geminiCacheEntry{Name: cached.Name, ExpireTime: expireTime})
+		if err != nil {
+			return "", err
+		}
+		_, err = c.kv.Put(ctx, key, value)
+		if err != nil {
+			log.Warningf("Cannot store the Gemini cached content '%s': %v", cached.Name, err)
+		}
+		return cached.Name, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return name.(string), nil
+}
+
+// cacheablePrefix returns the leading user contents of the history, e.g. documents sent ahead of the first
+// question. Later contents change with every turn and are not worth caching.
+func cacheablePrefix(history []*genai.Content) []*genai.Content {
+	for i, content := range history {
+		if content.Role != genai.RoleUser {
+			return history[:i]
+		}
+	}
+	return history
+}
+
+// geminiCacheKey hashes the model and everything stored in a cached content.
+func geminiCacheKey(model string, cacheConfig *genai.CreateCachedContentConfig) (string, error) {
+	data, err := json.Marshal(struct {
+		Model             string            `json:"model"`
+		Contents          []*genai.Content  `json:"contents"`
+		SystemInstruction *genai.Content    `json:"system_instruction"`
+		Tools             []*genai.Tool     `json:"tools"`
+		ToolConfig        *genai.ToolConfig `json:"tool_config"`
+	}{model, cacheConfig.Contents, cacheConfig.SystemInstruction, cacheConfig.Tools, cacheConfig.ToolConfig})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"testing"
+)
+
+func TestCacheablePrefix(t *testing.T) {
+	document := genai.NewContentFromText("A long document.", genai.RoleUser)
+	question := genai.NewContentFromText("Summarize the document.", genai.RoleUser)
+	answer := genai.NewContentFromText("The document is long.", genai.RoleModel)
+
+	assert.Equal(t, []*genai.Content{document, question}, cacheablePrefix([]*genai.Content{document, question, answer}))
+	assert.Equal(t, []*genai.Content{}, cacheablePrefix([]*genai.Content{answer, question}))
+	assert.Empty(t, cacheablePrefix(nil))
+}
+
+func TestGeminiCacheKey(t *testing.T) {
+	cacheConfig := &genai.CreateCachedContentConfig{
+		SystemInstruction: genai.NewContentFromText("You are a medical assistant.", genai.RoleUser),
+		Contents:          []*genai.Content{genai.NewContentFromText("A long document.", genai.RoleUser)},
+	}
+
+	key, err := geminiCacheKey("gemini-2.5-flash", cacheConfig)
+	assert.NoError(t, err)
+	sameKey, _ := geminiCacheKey("gemini-2.5-flash", cacheConfig)
+	otherModelKey, _ := geminiCacheKey("gemini-2.5-pro", cacheConfig)
+
+	assert.Len(t, key, 64)
+	assert.Equal(t, key, sameKey)
+	assert.NotEqual(t, key, otherModelKey)
+}
+
+func TestGeminiContextCacheApplySmallPrompt(t *testing.T) {
+	config := &genai.GenerateContentConfig{
+		SystemInstruction: genai.NewContentFromText("You are a medical assistant.", genai.RoleUser),
+	}
+	history := []*genai.Content{genai.NewContentFromText("Hello", genai.RoleUser)}
+	cache := &geminiContextCache{minTokens: 4096}
+
+	result := cache.apply(context.Background(), "gemini-2.5-flash", config, history)
+
+	assert.Equal(t, history, result)
+	assert.Empty(t, config.CachedContent)
+	assert.NotNil(t, config.SystemInstruction)
+
+	var disabledCache *geminiContextCache
+	assert.Equal(t, history, disabledCache.apply(context.Background(), "gemini-2.5-flash", config, history))
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ollama/ollama/api"
+	"google.golang.org/genai"
+	"slices"
+	"strings"
+)
+
+// maxSchemaDepth limits the nesting of translated schemas, which also stops recursive references.
+const maxSchemaDepth = 32
+
+// jsonSchema is the subset of JSON Schema which can be expressed as a genai.Schema.
+type jsonSchema struct {
+	Type        api.PropertyType       `json:"type,omitempty"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Enum        []any                  `json:"enum,omitempty"`
+	Const       any                    `json:"const,omitempty"`
+	Format      string                 `json:"format,omitempty"`
+	Default     any                    `json:"default,omitempty"`
+	Nullable    bool                   `json:"nullable,omitempty"`
+	Items       *jsonSchema            `json:"items,omitempty"`
+	Properties  map[string]*jsonSchema `json:"properties,omitempty"`
+	Required    []string               `json:"required,omitempty"`
+	AnyOf       []*jsonSchema          `json:"anyOf,omitempty"`
+	OneOf       []*jsonSchema          `json:"oneOf,omitempty"`
+	Ref         string                 `json:"$ref,omitempty"`
+	Defs        map[string]*jsonSchema `json:"$defs,omitempty"`
+	Definitions map[string]*jsonSchema `json:"definitions,omitempty"`
+	Minimum     *float64               `json:"minimum,omitempty"`
+	Maximum     *float64               `json:"maximum,omitempty"`
+	MinItems    *int64                 `json:"minItems,omitempty"`
+	MaxItems    *int64                 `json:"maxItems,omitempty"`
+	MinLength   *int64                 `json:"minLength,omitempty"`
+	MaxLength   *int64                 `json:"maxLength,omitempty"`
+	Pattern     string                 `json:"pattern,omitempty"`
+}
+
+// geminiFormats lists the formats supported by Gemini for each type. Other formats are dropped.
+var geminiFormats = map[genai.Type][]string{
+	genai.TypeString:  {"enum", "date-time"},
+	genai.TypeNumber:  {"float", "double"},
+	genai.TypeInteger: {"int32", "int64"},
+}
+
+// createGeminiParametersSchema translates the parameters of an Ollama tool into a genai.Schema.
+// Tools without any parameters have no schema. The api.ToolProperty of Ollama has no properties,
+// required or $ref fields, so these are already lost when the chat request is decoded: the
+// properties of a nested object only reach Gemini if the object is the items schema of an array.
+func createGeminiParametersSchema(parameters api.ToolFunctionParameters) (*genai.Schema, error) {
+	if parameters.Type == "" && len(parameters.Properties) == 0 {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := geminiSchemaFromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	if isUnspecifiedType(result.Type) {
+		result.Type = genai.TypeObject
+	}
+	return result, nil
+}
+
+// geminiSchemaFromJSON translates a JSON Schema document into a genai.Schema.
+func geminiSchemaFromJSON(data []byte) (*genai.Schema, error) {
+	var schema jsonSchema
+	err := json.Unmarshal(data, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+
+	defs := map[string]*jsonSchema{}
+	for name, def := range schema.Definitions {
+		defs["#/definitions/"+name] = def
+	}
+	for name, def := range schema.Defs {
+		defs["#/$defs/"+name] = def
+	}
+	return toGeminiSchema(&schema, defs, 0)
+}
+
+func toGeminiSchema(schema *jsonSchema, defs map[string]*jsonSchema, depth int) (*genai.Schema, error) {
+	if depth > maxSchemaDepth {
+		return nil, errors.New("JSON schema is nested too deeply or is recursive")
+	}
+
+	if schema.Ref != "" {
+		def, ok := defs[schema.Ref]
+		if !ok {
+			return nil, fmt.Errorf("unknown reference '%s' in JSON schema", schema.Ref)
+		}
+		result, err := toGeminiSchema(def, defs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		if schema.Description != "" {
+			result.Description = schema.Description
+		}
+		return result, nil
+	}
+
+	result := &genai.Schema{
+		Title:       schema.Title,
+		Description: schema.Description,
+		Default:     schema.Default,
+		Minimum:     schema.Minimum,
+		Maximum:     schema.Maximum,
+		MinItems:    schema.MinItems,
+		MaxItems:    schema.MaxItems,
+		MinLength:   schema.MinLength,
+		MaxLength:   schema.MaxLength,
+		Pattern:     schema.Pattern,
+		Required:    schema.Required,
+	}
+	nullable := schema.Nullable
+
+	// A list of types is either a nullable type, e.g. ["string", "null"], or a union type:
+	types := []genai.Type{}
+	for _, typeName := range schema.Type {
+		switch typeName {
+		case "":
+		case "null":
+			nullable = true
+		default:
+			types = append(types, mapOllamaType(typeName))
+		}
+	}
+	switch len(types) {
+	case 0:
+		if len(schema.Properties) > 0 {
+			result.Type = genai.TypeObject
+		} else if schema.Items != nil {
+			result.Type = genai.TypeArray
+		}
+	case 1:
+		result.Type = types[0]
+	default:
+		for _, t := range types {
+			result.AnyOf = append(result.AnyOf, &genai.Schema{Type: t})
+		}
+	}
+
+	enum := slices.Clone(schema.Enum)
+	if schema.Const != nil {
+		enum = append(enum, schema.Const)
+	}
+	format := schema.Format
+	enumValues := []string{}
+	for _, value := range enum {
+		if value == nil {
+			nullable = true
+			continue
+		}
+		enumValues = append(enumValues, fmt.Sprint(value))
+	}
+	// Gemini only accepts enums of strings, the values of other types are listed in the description:
+	if len(enumValues) > 0 {
+		if isUnspecifiedType(result.Type) && len(result.AnyOf) == 0 {
+			result.Type = genai.TypeString
+		}
+		if result.Type == genai.TypeString {
+			result.Enum = enumValues
+			format = "enum"
+		} else {
+			result.Description = strings.TrimSpace(fmt.Sprintf("%s Allowed values: %s.", result.Description, strings.Join(enumValues, ", ")))
+		}
+	}
+
+	for _, geminiFormat := range geminiFormats[result.Type] {
+		if strings.EqualFold(geminiFormat, format) {
+			result.Format = geminiFormat
+		}
+	}
+
+	if schema.Items != nil {
+		items, err := toGeminiSchema(schema.Items, defs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		result.Items = items
+	}
+
+	if len(schema.Properties) > 0 {
+		result.Properties = map[string]*genai.Schema{}
+		for name, property := range schema.Properties {
+			propertySchema, err := toGeminiSchema(property, defs, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("property '%s': %w", name, err)
+			}
+			result.Properties[name] = propertySchema
+		}
+	}
+
+	// Alternatives of type null only make the schema nullable. If a single alternative
+	// remains, it is merged into this schema instead of wrapping it in anyOf.
+	alternatives := []*genai.Schema{}
+	for _, alternative := range slices.Concat(schema.AnyOf, schema.OneOf) {
+		if len(alternative.Type) == 1 && alternative.Type[0] == "null" {
+			nullable = true
+			continue
+		}
+		alternativeSchema, err := toGeminiSchema(alternative, defs, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, alternativeSchema)
+	}
+	if len(alternatives) == 1 && isUnspecifiedType(result.Type) && len(result.AnyOf) == 0 {
+		description := result.Description
+		result = alternatives[0]
+		if description != "" {
+			result.Description = description
+		}
+	} else if len(alternatives) > 0 {
+		result.AnyOf = append(result.AnyOf, alternatives...)
+	}
+
+	if nullable {
+		result.Nullable = genai.Ptr(true)
+	}
+	return result, nil
+}
+
+func isUnspecifiedType(t genai.Type) bool {
+	return t == "" || t == genai.TypeUnspecified
+}
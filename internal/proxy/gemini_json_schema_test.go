@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"testing"
+)
+
+func TestCreateGeminiParametersSchema(t *testing.T) {
+	tt := []struct {
+		testName       string
+		inParameters   api.ToolFunctionParameters
+		expectedSchema *genai.Schema
+	}{
+		{
+			testName:       "no parameters",
+			inParameters:   api.ToolFunctionParameters{},
+			expectedSchema: nil,
+		},
+		{
+			testName: "required and enum",
+			inParameters: api.ToolFunctionParameters{
+				Type:     "object",
+				Required: []string{"city"},
+				Properties: map[string]api.ToolProperty{
+					"city": {Type: api.PropertyType{"string"}, Description: "The name of the city"},
+					"unit": {Type: api.PropertyType{"string"}, Enum: []any{"celsius", "fahrenheit"}},
+				},
+			},
+			expectedSchema: &genai.Schema{
+				Type:     genai.TypeObject,
+				Required: []string{"city"},
+				Properties: map[string]*genai.Schema{
+					"city": {Type: genai.TypeString, Description: "The name of the city"},
+					"unit": {Type: genai.TypeString, Format: "enum", Enum: []string{"celsius", "fahrenheit"}},
+				},
+			},
+		},
+		{
+			testName: "numeric enum",
+			inParameters: api.ToolFunctionParameters{
+				Type: "object",
+				Properties: map[string]api.ToolProperty{
+					"days":     {Type: api.PropertyType{"integer"}, Description: "Days to forecast.", Enum: []any{1, 3, 7}},
+					"accuracy": {Type: api.PropertyType{"number"}, Enum: []any{0.5, 1}},
+				},
+			},
+			expectedSchema: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"days":     {Type: genai.TypeInteger, Description: "Days to forecast. Allowed values: 1, 3, 7."},
+					"accuracy": {Type: genai.TypeNumber, Description: "Allowed values: 0.5, 1."},
+				},
+			},
+		},
+		{
+			testName: "nullable and union types",
+			inParameters: api.ToolFunctionParameters{
+				Type: "object",
+				Properties: map[string]api.ToolProperty{
+					"limit": {Type: api.PropertyType{"integer", "null"}},
+					"id":    {Type: api.PropertyType{"string", "integer"}},
+				},
+			},
+			expectedSchema: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"limit": {Type: genai.TypeInteger, Nullable: genai.Ptr(true)},
+					"id": {AnyOf: []*genai.Schema{
+						{Type: genai.TypeString},
+						{Type: genai.TypeInteger},
+					}},
+				},
+			},
+		},
+		{
+			testName: "array of nested objects",
+			inParameters: api.ToolFunctionParameters{
+				Type: "object",
+				Properties: map[string]api.ToolProperty{
+					"events": {
+						Type: api.PropertyType{"array"},
+						Items: map[string]any{
+							"type":     "object",
+							"required": []any{"date"},
+							"properties": map[string]any{
+								"date":  map[string]any{"type": "string", "format": "date-time"},
+								"email": map[string]any{"type": "string", "format": "email"},
+							},
+						},
+					},
+				},
+			},
+			expectedSchema: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"events": {
+						Type: genai.TypeArray,
+						Items: &genai.Schema{
+							Type:     genai.TypeObject,
+							Required: []string{"date"},
+							Properties: map[string]*genai.Schema{
+								"date":  {Type: genai.TypeString, Format: "date-time"},
+								"email": {Type: genai.TypeString},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			testName: "anyOf with null alternative",
+			inParameters: api.ToolFunctionParameters{
+				Type: "object",
+				Properties: map[string]api.ToolProperty{
+					"amount": {
+						Description: "The amount",
+						AnyOf: []api.ToolProperty{
+							{Type: api.PropertyType{"number"}},
+							{Type: api.PropertyType{"null"}},
+						},
+					},
+				},
+			},
+			expectedSchema: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"amount": {Type: genai.TypeNumber, Description: "The amount", Nullable: genai.Ptr(true)},
+				},
+			},
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			schema, err := createGeminiParametersSchema(td.inParameters)
+
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedSchema, schema)
+		})
+	}
+}
+
+// TestGeminiSchemaFromJSON translates complete JSON schemas, as sent as format of a chat request. The
+// parameters of tools lose the properties of nested objects before they are translated.
+func TestGeminiSchemaFromJSON(t *testing.T) {
+	tt := []struct {
+		testName       string
+		inSchema       string
+		expectedSchema *genai.Schema
+		expectedError  bool
+	}{
+		{
+			testName: "references",
+			inSchema: `{
+				"type": "object",
+				"properties": {"address": {"$ref": "#/$defs/Address", "description": "Home address"}},
+				"$defs": {"Address": {"type": "object", "properties": {"street": {"type": "string"}}}}
+			}`,
+			expectedSchema: &genai.Schema{
+				Type: genai.TypeObject,
+				Properties: map[string]*genai.Schema{
+					"address": {
+						Type:        genai.TypeObject,
+						Description: "Home address",
+						Properties:  map[string]*genai.Schema{"street": {Type: genai.TypeString}},
+					},
+				},
+			},
+		},
+		{
+			testName: "enum without type",
+			inSchema: `{"enum": ["low", "high", 3]}`,
+			expectedSchema: &genai.Schema{
+				Type:   genai.TypeString,
+				Format: "enum",
+				Enum:   []string{"low", "high", "3"},
+			},
+		},
+		{
+			testName:      "unknown reference",
+			inSchema:      `{"$ref": "#/$defs/Missing"}`,
+			expectedError: true,
+		},
+		{
+			testName:      "recursive reference",
+			inSchema:      `{"$ref": "#/$defs/Node", "$defs": {"Node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/Node"}}}}}`,
+			expectedError: true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			schema, err := geminiSchemaFromJSON([]byte(td.inSchema))
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedSchema, schema)
+		})
+	}
+}
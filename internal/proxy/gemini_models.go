@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genai"
+	"strings"
+	"sync"
+	"time"
+)
+
+// geminiModelCache keeps the list of available Gemini models and their default parameters, so listing
+// and showing models does not require a roundtrip to the Gemini API for every request.
+type geminiModelCache struct {
+	mu        sync.RWMutex
+	models    []*genai.Model
+	defaults  map[string]*geminiModelDefaults
+	updatedAt time.Time
+}
+
+func (c *geminiModelCache) get() ([]*genai.Model, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.models, !c.updatedAt.IsZero()
+}
+
+func (c *geminiModelCache) refresh(ctx context.Context, client *genai.Client) error {
+	models := []*genai.Model{}
+	for model, err := range client.Models.All(ctx) {
+		if err != nil {
+			return err
+		}
+		models = append(models, model)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.models = models
+	c.defaults = nil
+	c.updatedAt = time.Now()
+	return nil
+}
+
+// getDefaults returns the cached default parameters of a model. They are reloaded after each refresh
+// of the model list.
+func (c *geminiModelCache) getDefaults(modelName string) (*geminiModelDefaults, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	defaults, ok := c.defaults[modelName]
+	return defaults, ok
+}
+
+func (c *geminiModelCache) putDefaults(modelName string, defaults *geminiModelDefaults) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.defaults == nil {
+		c.defaults = map[string]*geminiModelDefaults{}
+	}
+	c.defaults[modelName] = defaults
+}
+
+// refreshPeriodically reloads the model list at the given interval until the context is done.
+func (c *geminiModelCache) refreshPeriodically(ctx context.Context, client *genai.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.refresh(ctx, client); err != nil {
+				log.Warningf("Cannot refresh the list of Gemini models: %v", err)
+			}
+		}
+	}
+}
+
+func (n *NatsGeminiProxy) listModels(ctx context.Context) ([]*genai.Model, error) {
+	models, ok := n.models.get()
+	if ok {
+		return models, nil
+	}
+
+	// The initial load failed or has not happened yet:
+	err := n.models.refresh(ctx, n.client)
+	if err != nil {
+		return nil, err
+	}
+	models, _ = n.models.get()
+	return models, nil
+}
+
+func createGeminiListResponse(models []*genai.Model, filter *ModelFilter) llm.GeminiListResponse {
+	result := llm.GeminiListResponse{Models: []llm.GeminiModel{}}
+	for _, model := range models {
+		name := geminiModelName(model.Name)
+		if !filter.Allowed(name) {
+			continue
+		}
+
+		result.Models = append(result.Models, llm.GeminiModel{
+			ListModelResponse: api.ListModelResponse{
+				Name:  name,
+				Model: name,
+				Details: api.ModelDetails{
+					Format:   family,
+					Family:   family,
+					Families: []string{family},
+				},
+			},
+			InputTokenLimit:  model.InputTokenLimit,
+			OutputTokenLimit: model.OutputTokenLimit,
+			SupportedActions: model.SupportedActions,
+		})
+	}
+	return result
+}
+
+// geminiModelName strips the resource prefix of a Gemini model name, e.g. "models/gemini-2.5-flash" of the
+// Gemini API or "publishers/google/models/gemini-2.5-flash" of Vertex AI.
+func geminiModelName(name string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(name, "publishers/google/"), "models/")
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"github.com/ollama/ollama/types/model"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateGeminiListResponse(t *testing.T) {
+	models := []*genai.Model{
+		{
+			Name:             "models/gemini-2.5-flash",
+			InputTokenLimit:  1048576,
+			OutputTokenLimit: 65536,
+			SupportedActions: []string{"generateContent", "countTokens"},
+		},
+		{
+			Name:             "models/gemini-embedding-001",
+			InputTokenLimit:  2048,
+			SupportedActions: []string{"embedContent"},
+		},
+	}
+	filter, _ := NewModelFilter(nil, []string{"*-embedding-*"})
+
+	result := createGeminiListResponse(models, filter)
+
+	assert.Len(t, result.Models, 1)
+	assert.Equal(t, "gemini-2.5-flash", result.Models[0].Name)
+	assert.Equal(t, "gemini-2.5-flash", result.Models[0].Model)
+	assert.Equal(t, int32(1048576), result.Models[0].InputTokenLimit)
+	assert.Equal(t, int32(65536), result.Models[0].OutputTokenLimit)
+	assert.Equal(t, []string{"generateContent", "countTokens"}, result.Models[0].SupportedActions)
+}
+
+func TestCreateOllamaShowResponse(t *testing.T) {
+	temperature := float32(1)
+	topP := float32(0.95)
+	tt := []struct {
+		testName             string
+		inModel              *genai.Model
+		inDefaults           *geminiModelDefaults
+		expectedCapabilities []model.Capability
+		expectedParameters   string
+	}{
+		{
+			testName: "chat model",
+			inModel: &genai.Model{
+				Name:             "models/gemini-2.0-flash",
+				InputTokenLimit:  1048576,
+				OutputTokenLimit: 8192,
+				SupportedActions: []string{"generateContent", "countTokens"},
+			},
+			expectedCapabilities: []model.Capability{model.CapabilityCompletion, model.CapabilityTools, model.CapabilityVision},
+		},
+		{
+			testName: "thinking model with defaults",
+			inModel: &genai.Model{
+				Name:             "models/gemini-2.5-flash",
+				SupportedActions: []string{"generateContent", "countTokens", "createCachedContent"},
+			},
+			inDefaults: &geminiModelDefaults{
+				Temperature: &temperature,
+				TopP:        &topP,
+			},
+			expectedCapabilities: []model.Capability{model.CapabilityCompletion, model.CapabilityTools, model.CapabilityVision, model.CapabilityThinking},
+			expectedParameters:   "temperature                    1\ntop_p                          0.95",
+		},
+		{
+			testName: "embedding model",
+			inModel: &genai.Model{
+				Name:             "models/gemini-embedding-001",
+				SupportedActions: []string{"embedContent", "countTextTokens"},
+			},
+			expectedCapabilities: []model.Capability{model.CapabilityEmbedding},
+		},
+		{
+			testName: "gemma model",
+			inModel: &genai.Model{
+				Name:             "models/gemma-3-27b-it",
+				SupportedActions: []string{"generateContent", "countTokens"},
+			},
+			expectedCapabilities: []model.Capability{model.CapabilityCompletion},
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			result, err := createOllamaShowResponse(td.inModel, td.inDefaults)
+
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedCapabilities, result.Capabilities)
+			assert.Equal(t, td.expectedParameters, result.Parameters)
+			assert.Equal(t, td.inModel.InputTokenLimit, result.ModelInfo["gemini.context_length"])
+			assert.Equal(t, td.inModel.OutputTokenLimit, result.ModelInfo["gemini.output_token_limit"])
+			assert.Equal(t, "gemini", result.Details.Family)
+		})
+	}
+}
+
+func TestGeminiModelName(t *testing.T) {
+	assert.Equal(t, "gemini-2.5-flash", geminiModelName("models/gemini-2.5-flash"))
+	assert.Equal(t, "gemini-2.5-flash", geminiModelName("publishers/google/models/gemini-2.5-flash"))
+	assert.Equal(t, "gemini-2.5-flash", geminiModelName("gemini-2.5-flash"))
+}
+
+func TestGeminiModelDefaults(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/v1beta/models/gemini-2.5-flash", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("x-goog-api-key"))
+		w.Write([]byte(`{"name": "models/gemini-2.5-flash", "temperature": 1, "topK": 64, "thinking": true}`))
+	}))
+	defer server.Close()
+
+	client, err := genai.NewClient(context.Background(), &genai.ClientConfig{
+		Backend:     genai.BackendGeminiAPI,
+		APIKey:      "key",
+		HTTPClient:  server.Client(),
+		HTTPOptions: genai.HTTPOptions{BaseURL: server.URL + "/", APIVersion: "v1beta"},
+	})
+	assert.NoError(t, err)
+	proxy := &NatsGeminiProxy{client: client}
+
+	for i := 0; i < 2; i++ {
+		defaults := proxy.modelDefaults(context.Background(), "models/gemini-2.5-flash")
+		assert.Equal(t, &geminiModelDefaults{Temperature: genai.Ptr[float32](1), TopK: genai.Ptr[int32](64), Thinking: genai.Ptr(true)}, defaults)
+	}
+	assert.Equal(t, 1, requests)
+}
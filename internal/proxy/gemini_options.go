@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ollama/ollama/api"
+	"google.golang.org/genai"
+	"math"
+	"slices"
+)
+
+// createGeminiGenerateConfig maps the tools, system prompt and options of an Ollama chat request
+// onto a Gemini generation config. Options without a Gemini equivalent are returned as warnings.
+func createGeminiGenerateConfig(reqData api.ChatRequest) (*genai.GenerateContentConfig, []string, error) {
+	tools, err := createGeminiToolSchema(reqData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &genai.GenerateContentConfig{
+		Tools:             tools,
+		SystemInstruction: createGeminiSystemPrompt(reqData),
+	}
+
+	err = applyGeminiFormat(config, reqData.Format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = applyGeminiThinking(config, reqData.Think)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warnings, err := applyGeminiOptions(config, reqData.Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, warnings, nil
+}
+
+// applyGeminiFormat maps the Ollama format, which is either "json" or a JSON schema, onto the
+// response MIME type and schema of Gemini.
+func applyGeminiFormat(config *genai.GenerateContentConfig, format json.RawMessage) error {
+	trimmedFormat := bytes.TrimSpace(format)
+	if len(trimmedFormat) == 0 || bytes.Equal(trimmedFormat, []byte("null")) || bytes.Equal(trimmedFormat, []byte(`""`)) {
+		return nil
+	}
+
+	var formatName string
+	if json.Unmarshal(trimmedFormat, &formatName) == nil {
+		if formatName != "json" {
+			return fmt.Errorf("unsupported format '%s', expecting 'json' or a JSON schema", formatName)
+		}
+		config.ResponseMIMEType = "application/json"
+		return nil
+	}
+
+	responseSchema, err := geminiSchemaFromJSON(trimmedFormat)
+	if err != nil {
+		return fmt.Errorf("format: %w", err)
+	}
+	config.ResponseMIMEType = "application/json"
+	config.ResponseSchema = responseSchema
+	return nil
+}
+
+// thinkingBudgets maps the Ollama thinking levels onto Gemini thinking budgets (in tokens).
+var thinkingBudgets = map[string]int32{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+// applyGeminiThinking maps the Ollama think value onto the thinking config of Gemini. Without a
+// think value the default of the model is used.
+func applyGeminiThinking(config *genai.GenerateContentConfig, think *api.ThinkValue) error {
+	if think == nil || think.Value == nil {
+		return nil
+	}
+
+	if think.IsBool() {
+		if think.Bool() {
+			config.ThinkingConfig = &genai.ThinkingConfig{IncludeThoughts: true}
+		} else {
+			config.ThinkingConfig = &genai.ThinkingConfig{ThinkingBudget: genai.Ptr(int32(0))}
+		}
+		return nil
+	}
+
+	budget, ok := thinkingBudgets[think.String()]
+	if !ok {
+		return fmt.Errorf("unsupported think value '%v', expecting a boolean, 'low', 'medium' or 'high'", think.Value)
+	}
+	config.ThinkingConfig = &genai.ThinkingConfig{
+		IncludeThoughts: true,
+		ThinkingBudget:  genai.Ptr(budget),
+	}
+	return nil
+}
+
+func applyGeminiOptions(config *genai.GenerateContentConfig, options map[string]any) ([]string, error) {
+	warnings := []string{}
+	for name, value := range options {
+		var err error
+		switch name {
+		case "temperature":
+			config.Temperature, err = float32Option(name, value)
+		case "top_p":
+			config.TopP, err = float32Option(name, value)
+		case "top_k":
+			config.TopK, err = float32Option(name, value)
+		case "presence_penalty":
+			config.PresencePenalty, err = float32Option(name, value)
+		case "frequency_penalty":
+			config.FrequencyPenalty, err = float32Option(name, value)
+		case "seed":
+			config.Seed, err = int32Option(name, value)
+		case "num_predict":
+			var numPredict *int32
+			numPredict, err = int32Option(name, value)
+			// Ollama uses negative values for an unlimited number of tokens:
+			if numPredict != nil && *numPredict > 0 {
+				config.MaxOutputTokens = *numPredict
+			}
+		case "stop":
+			config.StopSequences, err = stringsOption(name, value)
+		case "candidate_count":
+			// Gemini specific, further candidates are returned as alternatives:
+			var candidateCount *int32
+			candidateCount, err = int32Option(name, value)
+			if candidateCount != nil && *candidateCount > 0 {
+				config.CandidateCount = *candidateCount
+			}
+		default:
+			warnings = append(warnings, fmt.Sprintf("option '%s' is not supported by Gemini and was ignored", name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Map iteration order is random, sort the warnings to keep responses stable:
+	slices.Sort(warnings)
+	return warnings, nil
+}
+
+func float64Option(name string, value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	}
+	return 0, fmt.Errorf("option '%s' must be a number but was '%v'", name, value)
+}
+
+func float32Option(name string, value any) (*float32, error) {
+	v, err := float64Option(name, value)
+	if err != nil {
+		return nil, err
+	}
+	return genai.Ptr(float32(v)), nil
+}
+
+func int32Option(name string, value any) (*int32, error) {
+	v, err := float64Option(name, value)
+	if err != nil {
+		return nil, err
+	}
+	if v != math.Trunc(v) || v > math.MaxInt32 || v < math.MinInt32 {
+		return nil, fmt.Errorf("option '%s' must be a 32 bit integer but was '%v'", name, value)
+	}
+	return genai.Ptr(int32(v)), nil
+}
+
+func stringsOption(name string, value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []any:
+		result := []string{}
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("option '%s' must be a list of strings but contained '%v'", name, item)
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("option '%s' must be a list of strings but was '%v'", name, value)
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"testing"
+)
+
+func TestApplyGeminiOptions(t *testing.T) {
+	tt := []struct {
+		testName         string
+		inOptions        map[string]any
+		expectedConfig   *genai.GenerateContentConfig
+		expectedWarnings []string
+		expectedError    bool
+	}{
+		{
+			testName:         "no options",
+			inOptions:        nil,
+			expectedConfig:   &genai.GenerateContentConfig{},
+			expectedWarnings: []string{},
+		},
+		{
+			testName: "supported options",
+			inOptions: map[string]any{
+				"temperature":       0.2,
+				"top_p":             0.9,
+				"top_k":             float64(40),
+				"num_predict":       float64(256),
+				"stop":              []any{"</answer>", "\n\n"},
+				"seed":              float64(42),
+				"presence_penalty":  0.5,
+				"frequency_penalty": 0.25,
+				"candidate_count":   float64(3),
+			},
+			expectedConfig: &genai.GenerateContentConfig{
+				Temperature:      genai.Ptr(float32(0.2)),
+				TopP:             genai.Ptr(float32(0.9)),
+				TopK:             genai.Ptr(float32(40)),
+				MaxOutputTokens:  256,
+				StopSequences:    []string{"</answer>", "\n\n"},
+				Seed:             genai.Ptr(int32(42)),
+				PresencePenalty:  genai.Ptr(float32(0.5)),
+				FrequencyPenalty: genai.Ptr(float32(0.25)),
+				CandidateCount:   3,
+			},
+			expectedWarnings: []string{},
+		},
+		{
+			testName: "unlimited number of tokens",
+			inOptions: map[string]any{
+				"num_predict": float64(-1),
+			},
+			expectedConfig:   &genai.GenerateContentConfig{},
+			expectedWarnings: []string{},
+		},
+		{
+			testName: "unsupported options",
+			inOptions: map[string]any{
+				"temperature": 0.7,
+				"num_ctx":     float64(8192),
+				"min_p":       0.05,
+			},
+			expectedConfig: &genai.GenerateContentConfig{
+				Temperature: genai.Ptr(float32(0.7)),
+			},
+			expectedWarnings: []string{
+				"option 'min_p' is not supported by Gemini and was ignored",
+				"option 'num_ctx' is not supported by Gemini and was ignored",
+			},
+		},
+		{
+			testName: "invalid value",
+			inOptions: map[string]any{
+				"seed": 1.5,
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			config := &genai.GenerateContentConfig{}
+			warnings, err := applyGeminiOptions(config, td.inOptions)
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedConfig, config)
+			assert.Equal(t, td.expectedWarnings, warnings)
+		})
+	}
+}
+
+func TestApplyGeminiFormat(t *testing.T) {
+	tt := []struct {
+		testName       string
+		inFormat       string
+		expectedConfig *genai.GenerateContentConfig
+		expectedError  bool
+	}{
+		{
+			testName:       "no format",
+			inFormat:       "",
+			expectedConfig: &genai.GenerateContentConfig{},
+		},
+		{
+			testName: "json",
+			inFormat: `"json"`,
+			expectedConfig: &genai.GenerateContentConfig{
+				ResponseMIMEType: "application/json",
+			},
+		},
+		{
+			testName: "json schema",
+			inFormat: `{"type": "object", "properties": {"age": {"type": "integer"}}, "required": ["age"]}`,
+			expectedConfig: &genai.GenerateContentConfig{
+				ResponseMIMEType: "application/json",
+				ResponseSchema: &genai.Schema{
+					Type:       genai.TypeObject,
+					Required:   []string{"age"},
+					Properties: map[string]*genai.Schema{"age": {Type: genai.TypeInteger}},
+				},
+			},
+		},
+		{
+			testName:      "unsupported format",
+			inFormat:      `"yaml"`,
+			expectedError: true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			config := &genai.GenerateContentConfig{}
+			err := applyGeminiFormat(config, []byte(td.inFormat))
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedConfig, config)
+		})
+	}
+}
+
+func TestApplyGeminiThinking(t *testing.T) {
+	tt := []struct {
+		testName       string
+		inThink        *api.ThinkValue
+		expectedConfig *genai.ThinkingConfig
+		expectedError  bool
+	}{
+		{
+			testName:       "model default",
+			inThink:        nil,
+			expectedConfig: nil,
+		},
+		{
+			testName:       "enabled",
+			inThink:        &api.ThinkValue{Value: true},
+			expectedConfig: &genai.ThinkingConfig{IncludeThoughts: true},
+		},
+		{
+			testName:       "disabled",
+			inThink:        &api.ThinkValue{Value: false},
+			expectedConfig: &genai.ThinkingConfig{ThinkingBudget: genai.Ptr(int32(0))},
+		},
+		{
+			testName:       "level",
+			inThink:        &api.ThinkValue{Value: "low"},
+			expectedConfig: &genai.ThinkingConfig{IncludeThoughts: true, ThinkingBudget: genai.Ptr(int32(1024))},
+		},
+		{
+			testName:      "unsupported level",
+			inThink:       &api.ThinkValue{Value: "extreme"},
+			expectedError: true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			config := &genai.GenerateContentConfig{}
+			err := applyGeminiThinking(config, td.inThink)
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedConfig, config.ThinkingConfig)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/genai"
+	"os"
+	"slices"
+)
+
+var harmCategories = []genai.HarmCategory{
+	genai.HarmCategoryHateSpeech,
+	genai.HarmCategoryDangerousContent,
+	genai.HarmCategoryHarassment,
+	genai.HarmCategorySexuallyExplicit,
+	genai.HarmCategoryCivicIntegrity,
+	genai.HarmCategoryImageHate,
+	genai.HarmCategoryImageDangerousContent,
+	genai.HarmCategoryImageHarassment,
+	genai.HarmCategoryImageSexuallyExplicit,
+}
+
+var harmBlockThresholds = []genai.HarmBlockThreshold{
+	genai.HarmBlockThresholdBlockLowAndAbove,
+	genai.HarmBlockThresholdBlockMediumAndAbove,
+	genai.HarmBlockThresholdBlockOnlyHigh,
+	genai.HarmBlockThresholdBlockNone,
+	genai.HarmBlockThresholdOff,
+}
+
+var harmBlockMethods = []genai.HarmBlockMethod{
+	genai.HarmBlockMethodSeverity,
+	genai.HarmBlockMethodProbability,
+}
+
+// LoadGeminiSafetySettings reads the default safety settings of the Gemini proxy from a JSON file
+// containing a list of settings, e.g. [{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_ONLY_HIGH"}].
+func LoadGeminiSafetySettings(path string) ([]*genai.SafetySetting, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := parseGeminiSafetySettings(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid safety settings in '%s': %w", path, err)
+	}
+	return settings, nil
+}
+
+// parseGeminiSafetySettings decodes and validates a JSON list of safety settings.
+func parseGeminiSafetySettings(data []byte) ([]*genai.SafetySetting, error) {
+	var settings []*genai.SafetySetting
+	err := json.Unmarshal(data, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, setting := range settings {
+		if setting == nil {
+			return nil, fmt.Errorf("safety setting must not be null")
+		}
+		if !slices.Contains(harmCategories, setting.Category) {
+			return nil, fmt.Errorf("unknown harm category '%s'", setting.Category)
+		}
+		if !slices.Contains(harmBlockThresholds, setting.Threshold) {
+			return nil, fmt.Errorf("unknown threshold '%s' for harm category '%s'", setting.Threshold, setting.Category)
+		}
+		if setting.Method != "" && !slices.Contains(harmBlockMethods, setting.Method) {
+			return nil, fmt.Errorf("unknown method '%s' for harm category '%s'", setting.Method, setting.Category)
+		}
+	}
+	return settings, nil
+}
+
+// mergeGeminiSafetySettings returns the defaults of the proxy with the settings of a request applied
+// on top. A request setting replaces the default of the same harm category.
+func mergeGeminiSafetySettings(defaults []*genai.SafetySetting, overrides []*genai.SafetySetting) []*genai.SafetySetting {
+	if len(overrides) == 0 {
+		return defaults
+	}
+
+	var result []*genai.SafetySetting
+	for _, setting := range defaults {
+		overridden := slices.ContainsFunc(overrides, func(override *genai.SafetySetting) bool {
+			return override.Category == setting.Category
+		})
+		if !overridden {
+			result = append(result, setting)
+		}
+	}
+	return append(result, overrides...)
+}
+
+// requestSafetySettings reads the safety settings a client sent with the SafetySettingsHeader.
+func requestSafetySettings(header string) ([]*genai.SafetySetting, error) {
+	if header == "" {
+		return nil, nil
+	}
+
+	settings, err := parseGeminiSafetySettings([]byte(header))
+	if err != nil {
+		return nil, fmt.Errorf("invalid header '%s': %w", SafetySettingsHeader, err)
+	}
+	return settings, nil
+}
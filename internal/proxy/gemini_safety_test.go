@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"testing"
+)
+
+func TestParseGeminiSafetySettings(t *testing.T) {
+	tt := []struct {
+		testName         string
+		inData           string
+		expectedSettings []*genai.SafetySetting
+		expectedError    bool
+	}{
+		{
+			testName: "valid settings",
+			inData:   `[{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_ONLY_HIGH", "method": "SEVERITY"}]`,
+			expectedSettings: []*genai.SafetySetting{
+				{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockThresholdBlockOnlyHigh, Method: genai.HarmBlockMethodSeverity},
+			},
+		},
+		{
+			testName:      "unknown category",
+			inData:        `[{"category": "HARM_CATEGORY_MEDICAL", "threshold": "BLOCK_NONE"}]`,
+			expectedError: true,
+		},
+		{
+			testName:      "missing threshold",
+			inData:        `[{"category": "HARM_CATEGORY_HARASSMENT"}]`,
+			expectedError: true,
+		},
+		{
+			testName:      "not a list",
+			inData:        `{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"}`,
+			expectedError: true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			settings, err := parseGeminiSafetySettings([]byte(td.inData))
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedSettings, settings)
+		})
+	}
+}
+
+func TestMergeGeminiSafetySettings(t *testing.T) {
+	defaults := []*genai.SafetySetting{
+		{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockThresholdBlockOnlyHigh},
+		{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockThresholdBlockMediumAndAbove},
+	}
+	overrides := []*genai.SafetySetting{
+		{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockThresholdBlockNone},
+	}
+
+	result := mergeGeminiSafetySettings(defaults, overrides)
+
+	assert.Equal(t, []*genai.SafetySetting{
+		{Category: genai.HarmCategoryHarassment, Threshold: genai.HarmBlockThresholdBlockMediumAndAbove},
+		{Category: genai.HarmCategoryDangerousContent, Threshold: genai.HarmBlockThresholdBlockNone},
+	}, result)
+	assert.Equal(t, defaults, mergeGeminiSafetySettings(defaults, nil))
+}
@@ -4,75 +4,122 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/types/model"
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/genai"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 )
 
 func GetGeminiSchemaChat() (string, error) {
-	return marshalSchema(&api.ChatRequest{}, &api.ChatResponse{})
+	return marshalSchema(&api.ChatRequest{}, &llm.GeminiChatResponse{})
+}
+
+func GetGeminiSchemaCountTokens() (string, error) {
+	return marshalSchema(&api.ChatRequest{}, &llm.CountTokensResponse{})
 }
 
 func GetGeminiSchemaShow() (string, error) {
 	return marshalSchema(&api.ShowRequest{}, &api.ShowResponse{})
 }
 
-func createHistoryContent(reqData api.ChatRequest) []*genai.Content {
-	if len(reqData.Messages) == 1 {
-		return []*genai.Content{}
+func GetGeminiSchemaList() (string, error) {
+	return marshalSchema(&llm.ListRequest{}, &llm.GeminiListResponse{})
+}
+
+// createChatContents translates the messages of a request into the chat history and the parts of the
+// new message to send. The new message is either the last user message or all tool results following
+// the last assistant message.
+func createChatContents(reqData api.ChatRequest) ([]*genai.Content, []*genai.Part, error) {
+	if len(reqData.Messages) == 0 {
+		return nil, nil, errors.New("no message content found in the request")
 	}
-	result := []*genai.Content{}
 
-	// We assume that the last message is the user input:
-	messages := reqData.Messages[:len(reqData.Messages)-1]
-	for _, message := range messages {
-		role := message.Role
+	lastMessage := reqData.Messages[len(reqData.Messages)-1]
+	if strings.ToLower(lastMessage.Role) != "user" && strings.ToLower(lastMessage.Role) != "tool" {
+		return nil, nil, fmt.Errorf("message role must be 'user' or 'tool' but was '%s'", lastMessage.Role)
+	}
 
-		// We will skip system prompts as part of the history.
-		// Gemini handles system prompts separately.
-		if role == "system" {
+	contents, err := createContents(reqData.Messages)
+	if err != nil {
+		return nil, nil, err
+	}
+	return contents[:len(contents)-1], contents[len(contents)-1].Parts, nil
+}
+
+func createContents(messages []api.Message) ([]*genai.Content, error) {
+	result := []*genai.Content{}
+
+	// Tool calls of the latest assistant message, used to match the tool results following it:
+	toolCalls := []api.ToolCall{}
+	toolIndex := 0
+	previousRole := ""
+	for i, message := range messages {
+		role := strings.ToLower(message.Role)
+		message.Role = role
+		switch role {
+		case "system":
+			// We will skip system prompts as part of the history.
+			// Gemini handles system prompts separately.
 			continue
+		case "assistant":
+			toolCalls = message.ToolCalls
+			toolIndex = 0
+		case "tool":
+			if message.ToolName == "" && toolResultName(message.Content) == "" && toolIndex < len(toolCalls) {
+				message.ToolName = toolCalls[toolIndex].Function.Name
+			}
+			toolIndex++
+		}
+
+		parts, err := createContentParts(message)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
 		}
 
-		// Gemini uses role 'model' for llm generated messages while ollama uses the role 'assistant'
-		if role == "assistant" {
-			role = "model"
+		// Parallel tool results are sent back to Gemini within a single content:
+		if role == "tool" && previousRole == "tool" {
+			lastContent := result[len(result)-1]
+			lastContent.Parts = append(lastContent.Parts, parts...)
+			continue
 		}
+		previousRole = role
+
 		result = append(result, &genai.Content{
-			Role:  role,
-			Parts: createContentParts(message),
+			Role:  geminiRole(role),
+			Parts: parts,
 		})
 	}
-	return result
+	return result, nil
 }
 
-func createUserContentParts(reqData api.ChatRequest) ([]*genai.Part, error) {
-	// we assume that the last message is a user inMessage:
-	if len(reqData.Messages) == 0 {
-		return nil, errors.New("no message content found in the request")
-	}
-
-	userMessage := reqData.Messages[len(reqData.Messages)-1]
-	if strings.ToLower(userMessage.Role) != "user" && strings.ToLower(userMessage.Role) != "tool" {
-		return nil, errors.New(fmt.Sprintf("message role must be 'user' or 'tool' but was '%s'", userMessage.Role))
+// geminiRole maps an Ollama message role onto a Gemini role. Gemini uses role 'model' for llm generated
+// messages while ollama uses the role 'assistant'. Tool results are sent with role 'user'.
+func geminiRole(role string) string {
+	if role == "assistant" {
+		return genai.RoleModel
 	}
-
-	return createContentParts(userMessage), nil
+	return genai.RoleUser
 }
 
-func createContentParts(message api.Message) []*genai.Part {
+func createContentParts(message api.Message) ([]*genai.Part, error) {
 	parts := []*genai.Part{}
 	if len(message.Content) > 0 && message.Role != "tool" {
 		parts = append(parts, genai.NewPartFromText(message.Content))
 	}
 
 	if message.Role == "tool" {
-		toolResult := jsonToMap(message.Content)
-		parts = append(parts, genai.NewPartFromFunctionResponse(toolResult["name"].(string), toolResult))
+		name := message.ToolName
+		if name == "" {
+			name = toolResultName(message.Content)
+		}
+		if name == "" {
+			return nil, errors.New("cannot determine the tool of a tool message, please set 'tool_name'")
+		}
+		parts = append(parts, genai.NewPartFromFunctionResponse(name, toolResponse(message.Content)))
 	}
 
 	for _, toolCall := range message.ToolCalls {
@@ -84,37 +131,58 @@ func createContentParts(message api.Message) []*genai.Part {
 		parts = append(parts, genai.NewPartFromBytes(imageData, mimeType))
 	}
 
-	return parts
+	return parts, nil
 }
 
+// toolResponse returns the content of a tool message as a Gemini function response. Content which is not
+// a JSON object is wrapped into an object with the key 'result'.
+func toolResponse(content string) map[string]any {
+	var value any
+	err := json.Unmarshal([]byte(content), &value)
+	if err != nil {
+		return map[string]any{"result": content}
+	}
+
+	result, ok := value.(map[string]any)
+	if !ok {
+		return map[string]any{"result": value}
+	}
+	return result
+}
+
+// toolResultName returns the 'name' of a tool result, for clients sending the tool name within the content.
+func toolResultName(content string) string {
+	name, _ := toolResponse(content)["name"].(string)
+	return name
+}
+
+// createGeminiSystemPrompt combines all system messages, e.g. a system prompt and the summary of a
+// truncated history, into the system instruction.
 func createGeminiSystemPrompt(data api.ChatRequest) *genai.Content {
+	parts := []*genai.Part{}
 	for _, m := range data.Messages {
-		if m.Role == "system" {
-			return &genai.Content{
-				Role:  "system",
-				Parts: []*genai.Part{genai.NewPartFromText(m.Content)},
-			}
+		if strings.ToLower(m.Role) == "system" {
+			parts = append(parts, genai.NewPartFromText(m.Content))
 		}
 	}
-	return nil
+	if len(parts) == 0 {
+		return nil
+	}
+	return &genai.Content{
+		Role:  "system",
+		Parts: parts,
+	}
 }
 
-func createGeminiToolSchema(reqData api.ChatRequest) []*genai.Tool {
+func createGeminiToolSchema(reqData api.ChatRequest) ([]*genai.Tool, error) {
 	result := []*genai.Tool{}
 	geminiFunctions := []*genai.FunctionDeclaration{}
 	for _, tool := range reqData.Tools {
-		props := map[string]*genai.Schema{}
-		for name, prop := range tool.Function.Parameters.Properties {
-			props[name] = &genai.Schema{
-				Type:        mapOllamaType(prop.Type),
-				Description: prop.Description,
-			}
+		parametersSchema, err := createGeminiParametersSchema(tool.Function.Parameters)
+		if err != nil {
+			return nil, fmt.Errorf("tool '%s': %w", tool.Function.Name, err)
 		}
 
-		parametersSchema := &genai.Schema{
-			Type:       genai.TypeObject,
-			Properties: props,
-		}
 		geminiFunctions = append(geminiFunctions, &genai.FunctionDeclaration{
 			Name:        tool.Function.Name,
 			Description: tool.Function.Description,
@@ -130,41 +198,185 @@ func createGeminiToolSchema(reqData api.ChatRequest) []*genai.Tool {
 	}
 	//v, _ := json.Marshal(result)
 	//log.Infof("%v", string(v))
-	return result
+	return result, nil
 }
 
 const family = "gemini"
 
-func createOllamaShowResponse(modelInfo *genai.Model) (api.ShowResponse, error) {
-	capabilities := []model.Capability{}
-	for _, genMethod := range modelInfo.SupportedActions {
-		capabilities = append(capabilities, model.Capability(genMethod))
+// geminiModelDefaults are the default generation parameters of a Gemini model. The genai
+// client does not expose them, thus they are read from the REST API directly.
+type geminiModelDefaults struct {
+	Temperature    *float32 `json:"temperature,omitempty"`
+	MaxTemperature *float32 `json:"maxTemperature,omitempty"`
+	TopP           *float32 `json:"topP,omitempty"`
+	TopK           *int32   `json:"topK,omitempty"`
+	Thinking       *bool    `json:"thinking,omitempty"`
+}
+
+func createOllamaShowResponse(modelInfo *genai.Model, defaults *geminiModelDefaults) (api.ShowResponse, error) {
+	if defaults == nil {
+		defaults = &geminiModelDefaults{}
+	}
+
+	modelInfoMap := map[string]any{
+		"general.architecture":                       family,
+		fmt.Sprintf("%s.context_length", family):     modelInfo.InputTokenLimit,
+		fmt.Sprintf("%s.output_token_limit", family): modelInfo.OutputTokenLimit,
+	}
+	if modelInfo.DisplayName != "" {
+		modelInfoMap["general.name"] = modelInfo.DisplayName
+	}
+	if modelInfo.Description != "" {
+		modelInfoMap["general.description"] = modelInfo.Description
+	}
+	if modelInfo.Version != "" {
+		modelInfoMap["general.version"] = modelInfo.Version
 	}
 
-	result := api.ShowResponse{
-		ModelInfo: map[string]any{
-			fmt.Sprintf("%s.context_length", family): modelInfo.InputTokenLimit,
+	parameters := []string{}
+	if defaults.Temperature != nil {
+		parameters = append(parameters, fmt.Sprintf("%-30s %v", "temperature", *defaults.Temperature))
+	}
+	if defaults.TopP != nil {
+		parameters = append(parameters, fmt.Sprintf("%-30s %v", "top_p", *defaults.TopP))
+	}
+	if defaults.TopK != nil {
+		parameters = append(parameters, fmt.Sprintf("%-30s %v", "top_k", *defaults.TopK))
+	}
+	if defaults.MaxTemperature != nil {
+		modelInfoMap[fmt.Sprintf("%s.max_temperature", family)] = *defaults.MaxTemperature
+	}
+
+	return api.ShowResponse{
+		Parameters: strings.Join(parameters, "\n"),
+		Details: api.ModelDetails{
+			Format:   family,
+			Family:   family,
+			Families: []string{family},
 		},
-		Capabilities: capabilities,
+		ModelInfo:    modelInfoMap,
+		Capabilities: mapGeminiCapabilities(modelInfo, defaults),
+	}, nil
+}
+
+// mapGeminiCapabilities maps the supported actions of a Gemini model to Ollama capabilities.
+func mapGeminiCapabilities(modelInfo *genai.Model, defaults *geminiModelDefaults) []model.Capability {
+	name := strings.ToLower(geminiModelName(modelInfo.Name))
+	capabilities := []model.Capability{}
+	addCapability := func(capability model.Capability) {
+		if !slices.Contains(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
 	}
-	return result, nil
+
+	for _, action := range modelInfo.SupportedActions {
+		switch action {
+		case "generateContent":
+			addCapability(model.CapabilityCompletion)
+
+			// Gemini models accept images and support function calling, other models
+			// served by the Gemini API (e.g. Gemma or text-to-speech models) do not.
+			if strings.HasPrefix(name, "gemini-") && !strings.Contains(name, "tts") {
+				addCapability(model.CapabilityTools)
+				addCapability(model.CapabilityVision)
+			}
+		case "embedContent", "batchEmbedContents", "embedText":
+			addCapability(model.CapabilityEmbedding)
+		}
+	}
+
+	thinking := strings.Contains(name, "thinking") || strings.HasPrefix(name, "gemini-2.5") || strings.HasPrefix(name, "gemini-3")
+	if defaults.Thinking != nil {
+		thinking = *defaults.Thinking
+	}
+	if thinking && slices.Contains(capabilities, model.CapabilityCompletion) {
+		addCapability(model.CapabilityThinking)
+	}
+	return capabilities
 }
 
-func createOllamaChatResponse(resp *genai.GenerateContentResponse) (api.ChatResponse, error) {
-	if len(resp.Candidates) > 1 {
-		return api.ChatResponse{}, errors.New("too many candidates. expecting only one candidate")
+// blockedError is returned if Gemini blocked a prompt or the response to it, e.g. by its safety filters.
+type blockedError struct {
+	feedback llm.SafetyFeedback
+}
+
+func (e *blockedError) Error() string {
+	if e.feedback.Message != "" {
+		return fmt.Sprintf("blocked by Gemini (%s): %s", e.feedback.BlockReason, e.feedback.Message)
 	}
+	return fmt.Sprintf("blocked by Gemini (%s)", e.feedback.BlockReason)
+}
 
-	responseText := ""
-	toolCalls := []api.ToolCall{}
+// blockingFinishReasons are the finish reasons of candidates which were stopped by a filter of Gemini.
+var blockingFinishReasons = []genai.FinishReason{
+	genai.FinishReasonSafety,
+	genai.FinishReasonRecitation,
+	genai.FinishReasonBlocklist,
+	genai.FinishReasonProhibitedContent,
+	genai.FinishReasonSPII,
+	genai.FinishReasonImageSafety,
+	genai.FinishReasonImageProhibitedContent,
+}
 
-	if len(resp.Candidates) != 1 {
-		log.Errorf("We seem to get zero or more than one candidate. Not something we are expecting.")
+// createOllamaChatResponse maps the first candidate of a Gemini response onto an Ollama chat response
+// and returns any further candidates as alternatives. A blocked prompt or a blocked first candidate is
+// returned as blockedError.
+func createOllamaChatResponse(resp *genai.GenerateContentResponse) (llm.GeminiChatResponse, error) {
+	if resp.PromptFeedback != nil && resp.PromptFeedback.BlockReason != "" {
+		return llm.GeminiChatResponse{}, &blockedError{feedback: llm.SafetyFeedback{
+			BlockReason:   string(resp.PromptFeedback.BlockReason),
+			Message:       resp.PromptFeedback.BlockReasonMessage,
+			SafetyRatings: createSafetyRatings(resp.PromptFeedback.SafetyRatings),
+		}}
+	}
+	if len(resp.Candidates) == 0 {
+		return llm.GeminiChatResponse{}, errors.New("the response of Gemini contains no candidates")
 	}
+
 	candidate := resp.Candidates[0]
+	message := createOllamaMessage(candidate)
+	if slices.Contains(blockingFinishReasons, candidate.FinishReason) && message.Content == "" && len(message.ToolCalls) == 0 {
+		return llm.GeminiChatResponse{}, &blockedError{feedback: llm.SafetyFeedback{
+			BlockReason:   string(candidate.FinishReason),
+			Message:       candidate.FinishMessage,
+			SafetyRatings: createSafetyRatings(candidate.SafetyRatings),
+		}}
+	}
+
+	var alternatives []llm.GeminiAlternative
+	for _, alternative := range resp.Candidates[1:] {
+		alternatives = append(alternatives, llm.GeminiAlternative{
+			Message:       createOllamaMessage(alternative),
+			DoneReason:    string(alternative.FinishReason),
+			SafetyRatings: createSafetyRatings(alternative.SafetyRatings),
+		})
+	}
+
+	return llm.GeminiChatResponse{
+		ChatResponse: api.ChatResponse{
+			CreatedAt:  time.Now(),
+			Message:    message,
+			DoneReason: string(candidate.FinishReason),
+			Done:       candidate.FinishReason == genai.FinishReasonStop,
+		},
+		SafetyRatings: createSafetyRatings(candidate.SafetyRatings),
+		Alternatives:  alternatives,
+	}, nil
+}
+
+func createOllamaMessage(candidate *genai.Candidate) api.Message {
+	responseText := ""
+	thinking := ""
+	toolCalls := []api.ToolCall{}
+
 	if candidate.Content != nil {
 		for _, part := range candidate.Content.Parts {
-			responseText += part.Text
+			// Thought summaries are only returned if the request asked to include thoughts:
+			if part.Thought {
+				thinking += part.Text
+			} else {
+				responseText += part.Text
+			}
 
 			if part.FunctionCall != nil {
 				toolCalls = append(toolCalls, api.ToolCall{
@@ -177,21 +389,31 @@ func createOllamaChatResponse(resp *genai.GenerateContentResponse) (api.ChatResp
 		}
 	}
 
-	return api.ChatResponse{
-		CreatedAt: time.Now(),
-		Message: api.Message{
-			Content:   responseText,
-			Role:      "assistant",
-			ToolCalls: toolCalls,
-		},
-		DoneReason: string(candidate.FinishReason),
-		Done:       candidate.FinishReason == genai.FinishReasonStop,
-	}, nil
+	return api.Message{
+		Content:   responseText,
+		Thinking:  thinking,
+		Role:      "assistant",
+		ToolCalls: toolCalls,
+	}
+}
+
+func createSafetyRatings(ratings []*genai.SafetyRating) []llm.SafetyRating {
+	var result []llm.SafetyRating
+	for _, rating := range ratings {
+		result = append(result, llm.SafetyRating{
+			Category:    string(rating.Category),
+			Probability: string(rating.Probability),
+			Severity:    string(rating.Severity),
+			Blocked:     rating.Blocked,
+		})
+	}
+	return result
 }
 
-func mapOllamaType(propertyType api.PropertyType) genai.Type {
+func mapOllamaType(typeName string) genai.Type {
 	var typesForNames = map[string]genai.Type{
 		"string":  genai.TypeString,
+		"number":  genai.TypeNumber,
 		"double":  genai.TypeNumber,
 		"float":   genai.TypeNumber,
 		"integer": genai.TypeInteger,
@@ -201,15 +423,9 @@ func mapOllamaType(propertyType api.PropertyType) genai.Type {
 		"object":  genai.TypeObject,
 	}
 
-	result, ok := typesForNames[propertyType[0]]
+	result, ok := typesForNames[strings.ToLower(typeName)]
 	if !ok {
 		return genai.TypeUnspecified
 	}
 	return result
 }
-
-func jsonToMap(jsonStr string) map[string]interface{} {
-	result := make(map[string]interface{})
-	json.Unmarshal([]byte(jsonStr), &result)
-	return result
-}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"github.com/hofer/nats-llm/pkq/llm"
 	"github.com/ollama/ollama/api"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/genai"
@@ -65,15 +66,195 @@ func TestCreateContentParts(t *testing.T) {
 				}),
 			},
 		},
+		{
+			testName: "tool response with tool name",
+			inMessage: api.Message{
+				Role:     "tool",
+				ToolName: "get_temperature",
+				Content:  "21 degrees celsius",
+			},
+			expectedPart: []*genai.Part{
+				genai.NewPartFromFunctionResponse("get_temperature", map[string]any{
+					"result": "21 degrees celsius",
+				}),
+			},
+		},
+		{
+			testName: "tool response with JSON array",
+			inMessage: api.Message{
+				Role:     "tool",
+				ToolName: "list_cities",
+				Content:  "[\"Bern\", \"Zurich\"]",
+			},
+			expectedPart: []*genai.Part{
+				genai.NewPartFromFunctionResponse("list_cities", map[string]any{
+					"result": []any{"Bern", "Zurich"},
+				}),
+			},
+		},
 	}
 
 	for _, td := range tt {
 		t.Run(td.testName, func(t *testing.T) {
 			//act
-			parts := createContentParts(td.inMessage)
+			parts, err := createContentParts(td.inMessage)
 
 			//assert
+			assert.NoError(t, err)
 			assert.Equal(t, td.expectedPart, parts)
 		})
 	}
 }
+
+func TestCreateOllamaChatResponse(t *testing.T) {
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{
+				Content: &genai.Content{
+					Role: "model",
+					Parts: []*genai.Part{
+						{Text: "The user asks for a greeting.", Thought: true},
+						{Text: "Hello World"},
+					},
+				},
+				FinishReason: genai.FinishReasonStop,
+			},
+		},
+	}
+
+	result, err := createOllamaChatResponse(resp)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", result.Message.Content)
+	assert.Equal(t, "The user asks for a greeting.", result.Message.Thinking)
+	assert.Equal(t, "assistant", result.Message.Role)
+	assert.True(t, result.Done)
+}
+
+func TestCreateOllamaChatResponseAlternatives(t *testing.T) {
+	resp := &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{
+				Content:      genai.NewContentFromText("Hello World", genai.RoleModel),
+				FinishReason: genai.FinishReasonStop,
+				SafetyRatings: []*genai.SafetyRating{
+					{Category: genai.HarmCategoryHarassment, Probability: genai.HarmProbabilityNegligible},
+				},
+			},
+			{
+				Content:      genai.NewContentFromText("Hi there", genai.RoleModel),
+				FinishReason: genai.FinishReasonMaxTokens,
+			},
+		},
+	}
+
+	result, err := createOllamaChatResponse(resp)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", result.Message.Content)
+	assert.Equal(t, []llm.SafetyRating{{Category: "HARM_CATEGORY_HARASSMENT", Probability: "NEGLIGIBLE"}}, result.SafetyRatings)
+	assert.Len(t, result.Alternatives, 1)
+	assert.Equal(t, "Hi there", result.Alternatives[0].Message.Content)
+	assert.Equal(t, "MAX_TOKENS", result.Alternatives[0].DoneReason)
+}
+
+func TestCreateOllamaChatResponseBlocked(t *testing.T) {
+	tt := []struct {
+		testName         string
+		inResponse       *genai.GenerateContentResponse
+		expectedFeedback llm.SafetyFeedback
+	}{
+		{
+			testName: "blocked prompt",
+			inResponse: &genai.GenerateContentResponse{
+				PromptFeedback: &genai.GenerateContentResponsePromptFeedback{
+					BlockReason: genai.BlockedReasonSafety,
+					SafetyRatings: []*genai.SafetyRating{
+						{Category: genai.HarmCategoryDangerousContent, Probability: genai.HarmProbabilityHigh, Blocked: true},
+					},
+				},
+			},
+			expectedFeedback: llm.SafetyFeedback{
+				BlockReason:   "SAFETY",
+				SafetyRatings: []llm.SafetyRating{{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Probability: "HIGH", Blocked: true}},
+			},
+		},
+		{
+			testName: "blocked candidate",
+			inResponse: &genai.GenerateContentResponse{
+				Candidates: []*genai.Candidate{
+					{FinishReason: genai.FinishReasonRecitation, FinishMessage: "recitation"},
+				},
+			},
+			expectedFeedback: llm.SafetyFeedback{
+				BlockReason: "RECITATION",
+				Message:     "recitation",
+			},
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			_, err := createOllamaChatResponse(td.inResponse)
+
+			var blocked *blockedError
+			assert.ErrorAs(t, err, &blocked)
+			assert.Equal(t, td.expectedFeedback, blocked.feedback)
+		})
+	}
+}
+
+func TestCreateOllamaChatResponseNoCandidates(t *testing.T) {
+	_, err := createOllamaChatResponse(&genai.GenerateContentResponse{})
+
+	assert.Error(t, err)
+}
+
+func TestCreateContentPartsUnknownTool(t *testing.T) {
+	_, err := createContentParts(api.Message{
+		Role:    "tool",
+		Content: "21 degrees celsius",
+	})
+
+	assert.Error(t, err)
+}
+
+func TestCreateChatContents(t *testing.T) {
+	reqData := api.ChatRequest{
+		Messages: []api.Message{
+			{Role: "system", Content: "You are a weather bot."},
+			{Role: "user", Content: "What is the temperature in Bern and Zurich?"},
+			{Role: "assistant", ToolCalls: []api.ToolCall{
+				{Function: api.ToolCallFunction{Name: "get_temperature", Arguments: map[string]any{"city": "Bern"}}},
+				{Function: api.ToolCallFunction{Name: "get_humidity", Arguments: map[string]any{"city": "Zurich"}}},
+			}},
+			{Role: "tool", Content: "21 degrees celsius"},
+			{Role: "tool", Content: "{\"humidity\": 0.4}"},
+		},
+	}
+
+	history, parts, err := createChatContents(reqData)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []*genai.Content{
+		{Role: genai.RoleUser, Parts: []*genai.Part{genai.NewPartFromText("What is the temperature in Bern and Zurich?")}},
+		{Role: genai.RoleModel, Parts: []*genai.Part{
+			genai.NewPartFromFunctionCall("get_temperature", map[string]any{"city": "Bern"}),
+			genai.NewPartFromFunctionCall("get_humidity", map[string]any{"city": "Zurich"}),
+		}},
+	}, history)
+	assert.Equal(t, []*genai.Part{
+		genai.NewPartFromFunctionResponse("get_temperature", map[string]any{"result": "21 degrees celsius"}),
+		genai.NewPartFromFunctionResponse("get_humidity", map[string]any{"humidity": 0.4}),
+	}, parts)
+}
+
+func TestCreateChatContentsInvalidLastMessage(t *testing.T) {
+	_, _, err := createChatContents(api.ChatRequest{
+		Messages: []api.Message{
+			{Role: "assistant", Content: "Hello"},
+		},
+	})
+
+	assert.Error(t, err)
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"github.com/nats-io/nats.go/micro"
 	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"testing"
 )
 
 //func TestStartNatsGeminiProxy(t *testing.T) {
@@ -18,6 +21,70 @@ import (
 //	create
 //}
 
+func TestCreateGeminiClientConfig(t *testing.T) {
+	tt := []struct {
+		testName       string
+		inConfig       GeminiProxyConfig
+		expectedConfig *genai.ClientConfig
+		expectedError  bool
+	}{
+		{
+			testName:       "gemini api",
+			inConfig:       GeminiProxyConfig{APIKey: "key"},
+			expectedConfig: &genai.ClientConfig{Backend: genai.BackendGeminiAPI, APIKey: "key"},
+		},
+		{
+			testName:       "vertex ai",
+			inConfig:       GeminiProxyConfig{APIKey: "key", Backend: GeminiBackendVertex, Project: "my-project", Location: "europe-west6"},
+			expectedConfig: &genai.ClientConfig{Backend: genai.BackendVertexAI, Project: "my-project", Location: "europe-west6"},
+		},
+		{
+			testName:      "vertex ai without location",
+			inConfig:      GeminiProxyConfig{Backend: GeminiBackendVertex, Project: "my-project"},
+			expectedError: true,
+		},
+		{
+			testName:      "missing credentials file",
+			inConfig:      GeminiProxyConfig{Backend: GeminiBackendVertex, Project: "my-project", Location: "europe-west6", CredentialsFile: "does-not-exist.json"},
+			expectedError: true,
+		},
+		{
+			testName:      "unknown backend",
+			inConfig:      GeminiProxyConfig{Backend: "azure"},
+			expectedError: true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			config, err := createGeminiClientConfig(td.inConfig)
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedConfig, config)
+		})
+	}
+}
+
+func TestCreateGeminiCountTokensRequest(t *testing.T) {
+	systemPrompt := &genai.Content{Role: "system", Parts: []*genai.Part{genai.NewPartFromText("You are a weather bot.")}}
+	tools := []*genai.Tool{{FunctionDeclarations: []*genai.FunctionDeclaration{{Name: "get_temperature"}}}}
+	contents := []*genai.Content{genai.NewContentFromText("What is the temperature in Bern?", genai.RoleUser)}
+
+	vertexContents, vertexConfig, vertexEstimate := createGeminiCountTokensRequest(genai.BackendVertexAI, systemPrompt, tools, contents)
+	assert.Equal(t, contents, vertexContents)
+	assert.Equal(t, &genai.CountTokensConfig{SystemInstruction: systemPrompt, Tools: tools}, vertexConfig)
+	assert.Equal(t, 0, vertexEstimate)
+
+	apiContents, apiConfig, apiEstimate := createGeminiCountTokensRequest(genai.BackendGeminiAPI, systemPrompt, tools, contents)
+	assert.Equal(t, []*genai.Content{{Role: genai.RoleUser, Parts: systemPrompt.Parts}, contents[0]}, apiContents)
+	assert.Nil(t, apiConfig)
+	assert.Greater(t, apiEstimate, 0)
+}
+
 type DummyRequest struct {
 }
 
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"encoding/json"
+	"google.golang.org/genai"
+)
+
+// tokensPerMediaPart is the number of tokens Gemini charges for an image up to 384x384 pixels. It is used
+// as estimate for all inline or file data.
+const tokensPerMediaPart = 258
+
+// estimateTokens roughly estimates the number of tokens of the given contents without calling Gemini,
+// assuming an average of four characters per token.
+func estimateTokens(contents ...*genai.Content) int {
+	characters := 0
+	mediaParts := 0
+	for _, content := range contents {
+		if content == nil {
+			continue
+		}
+		for _, part := range content.Parts {
+			characters += len(part.Text)
+			if part.InlineData != nil || part.FileData != nil {
+				mediaParts++
+			}
+			if part.FunctionCall != nil {
+				characters += len(part.FunctionCall.Name) + jsonLength(part.FunctionCall.Args)
+			}
+			if part.FunctionResponse != nil {
+				characters += len(part.FunctionResponse.Name) + jsonLength(part.FunctionResponse.Response)
+			}
+		}
+	}
+	return characters/4 + mediaParts*tokensPerMediaPart
+}
+
+func jsonLength(value any) int {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,18 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genai"
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	contents := []*genai.Content{
+		genai.NewContentFromText("0123456789abcdef", genai.RoleUser),
+		genai.NewContentFromBytes([]byte{0x89, 0x50, 0x4e, 0x47}, "image/png", genai.RoleUser),
+		nil,
+	}
+
+	assert.Equal(t, 4+tokensPerMediaPart, estimateTokens(contents...))
+	assert.Equal(t, 0, estimateTokens())
+}
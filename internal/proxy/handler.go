@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/nats-io/nuid"
+	log "github.com/sirupsen/logrus"
+	"runtime/debug"
+	"sync"
+)
+
+// HandlerStats are the custom stats reported for each endpoint of a proxy service.
+type HandlerStats struct {
+	Panics int64 `json:"panics"`
+}
+
+// handlerMetrics isolates requests from each other by recovering panics of endpoint handlers and
+// counts them per endpoint subject.
+type handlerMetrics struct {
+	mu     sync.Mutex
+	panics map[string]int64
+}
+
+// recoverHandler wraps a handler, so a panic while processing a single request is logged and answered
+// with a service error instead of crashing the whole proxy.
+func (m *handlerMetrics) recoverHandler(handler micro.HandlerFunc) micro.Handler {
+	return micro.HandlerFunc(func(req micro.Request) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			m.countPanic(req.Subject())
+			requestID := requestID(req)
+			log.WithFields(log.Fields{
+				"request_id": requestID,
+				"subject":    req.Subject(),
+			}).Errorf("Recovered from panic: %v\n%s", recovered, debug.Stack())
+			req.Error("500", fmt.Sprintf("internal error while processing request '%s'", requestID), nil)
+		}()
+		handler(req)
+	})
+}
+
+// actionPanic is a panic of a spinner action, raised again in the goroutine of the handler.
+type actionPanic struct {
+	recovered any
+	stack     []byte
+}
+
+func (p actionPanic) Error() string {
+	return fmt.Sprintf("%v\n%s", p.recovered, p.stack)
+}
+
+// runSpinner runs the action with the spinner and waits for it to finish. The spinner runs the action in
+// its own goroutine, which is not covered by recoverHandler, thus a panic of the action is recovered there
+// and raised again in the goroutine of the handler.
+func runSpinner(sp *spinner.Spinner, action func()) error {
+	var panicked *actionPanic
+	done := make(chan struct{})
+	err := sp.Action(func() {
+		defer close(done)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				panicked = &actionPanic{recovered: recovered, stack: debug.Stack()}
+			}
+		}()
+		action()
+	}).Run()
+	<-done
+	if panicked != nil {
+		panic(*panicked)
+	}
+	return err
+}
+
+func (m *handlerMetrics) countPanic(subject string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.panics == nil {
+		m.panics = map[string]int64{}
+	}
+	m.panics[subject]++
+}
+
+// stats is used as StatsHandler of the micro service, adding the panic count to the stats of each endpoint.
+func (m *handlerMetrics) stats(endpoint *micro.Endpoint) any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return HandlerStats{
+		Panics: m.panics[endpoint.Subject],
+	}
+}
+
+// requestID returns the message ID set by the client or a generated ID.
+func requestID(req micro.Request) string {
+	id := req.Headers().Get(nats.MsgIdHdr)
+	if id == "" {
+		id = nuid.Next()
+	}
+	return id
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"github.com/charmbracelet/huh/spinner"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	metrics := &handlerMetrics{}
+	handler := metrics.recoverHandler(func(req micro.Request) {
+		var candidates []string
+		_ = candidates[0]
+	})
+
+	req := &headerRequest{subject: "gemini.chat"}
+	assert.NotPanics(t, func() {
+		handler.Handle(req)
+	})
+
+	assert.Equal(t, "500", req.errorCode)
+	assert.Equal(t, HandlerStats{Panics: 1}, metrics.stats(&micro.Endpoint{
+		EndpointConfig: micro.EndpointConfig{Subject: "gemini.chat"},
+	}))
+}
+
+func TestRecoverHandlerSpinnerAction(t *testing.T) {
+	metrics := &handlerMetrics{}
+	handler := metrics.recoverHandler(func(req micro.Request) {
+		runSpinner(spinner.New(), func() {
+			var candidates []string
+			_ = candidates[0]
+		})
+	})
+
+	req := &headerRequest{subject: "gemini.chat"}
+	assert.NotPanics(t, func() {
+		handler.Handle(req)
+	})
+
+	assert.Equal(t, "500", req.errorCode)
+	assert.Equal(t, HandlerStats{Panics: 1}, metrics.stats(&micro.Endpoint{
+		EndpointConfig: micro.EndpointConfig{Subject: "gemini.chat"},
+	}))
+}
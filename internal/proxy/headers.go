@@ -0,0 +1,15 @@
+package proxy
+
+import "github.com/hofer/nats-llm/pkq/llm"
+
+const (
+	// AdminTokenHeader is the NATS header carrying the token required by the model management endpoints.
+	AdminTokenHeader = llm.AdminTokenHeader
+
+	// WarningsHeader is the NATS response header listing request settings which were ignored by the proxy.
+	WarningsHeader = "Nats-Llm-Warnings"
+
+	// SafetySettingsHeader is the NATS header carrying a JSON list of Gemini safety settings, which
+	// override the default settings of the proxy for a single request.
+	SafetySettingsHeader = "Nats-Llm-Safety-Settings"
+)
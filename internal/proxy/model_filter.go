@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+)
+
+// ModelFilter restricts which models clients may request through a proxy. Patterns
+// use the syntax of path.Match, e.g. "gemma3:*" or "gemini-2.5-*". Deny patterns
+// take precedence over allow patterns and an empty allow list allows all models.
+type ModelFilter struct {
+	allow []string
+	deny  []string
+}
+
+func NewModelFilter(allow []string, deny []string) (*ModelFilter, error) {
+	for _, pattern := range append(append([]string{}, allow...), deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid model pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return &ModelFilter{
+		allow: allow,
+		deny:  deny,
+	}, nil
+}
+
+// Allowed reports whether the given model may be used. Patterns are matched against the
+// name as given and its normalized form, so "gemma3:*" also matches "gemma3". A nil
+// filter allows all models.
+func (f *ModelFilter) Allowed(model string) bool {
+	if f == nil {
+		return true
+	}
+
+	names := []string{model, normalizeModelName(model)}
+	if matchAny(f.deny, names) {
+		return false
+	}
+	return len(f.allow) == 0 || matchAny(f.allow, names)
+}
+
+func matchAny(patterns []string, names []string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func modelNotAllowedError(model string) error {
+	return fmt.Errorf("model '%s' is not allowed on this proxy", model)
+}
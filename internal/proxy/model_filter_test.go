@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestModelFilterAllowed(t *testing.T) {
+	tt := []struct {
+		testName string
+		allow    []string
+		deny     []string
+		model    string
+		expected bool
+	}{
+		{
+			testName: "no patterns",
+			model:    "gemma3:27b",
+			expected: true,
+		},
+		{
+			testName: "allowed by pattern",
+			allow:    []string{"gemma3:*"},
+			model:    "gemma3:4b",
+			expected: true,
+		},
+		{
+			testName: "allowed by pattern with implicit tag",
+			allow:    []string{"gemma3:latest"},
+			model:    "gemma3",
+			expected: true,
+		},
+		{
+			testName: "not in allow list",
+			allow:    []string{"gemma3:*"},
+			model:    "llama3.3:70b",
+			expected: false,
+		},
+		{
+			testName: "denied by pattern",
+			deny:     []string{"*:70b"},
+			model:    "llama3.3:70b",
+			expected: false,
+		},
+		{
+			testName: "deny takes precedence",
+			allow:    []string{"gemma3:*"},
+			deny:     []string{"gemma3:27b"},
+			model:    "gemma3:27b",
+			expected: false,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			filter, err := NewModelFilter(td.allow, td.deny)
+			assert.NoError(t, err)
+			assert.Equal(t, td.expected, filter.Allowed(td.model))
+		})
+	}
+}
+
+func TestNewModelFilterInvalidPattern(t *testing.T) {
+	_, err := NewModelFilter([]string{"gemma3:["}, nil)
+	assert.Error(t, err)
+}
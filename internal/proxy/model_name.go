@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"github.com/ollama/ollama/types/model"
+	"strings"
+)
+
+// normalizeModelName returns the shortest unambiguous form of an Ollama model name,
+// e.g. "gemma3" and "registry.ollama.ai/library/gemma3:latest" both become "gemma3:latest".
+// Names which cannot be parsed are returned unchanged.
+func normalizeModelName(name string) string {
+	parsed := model.ParseName(name)
+	if !parsed.IsValid() {
+		return name
+	}
+	return parsed.DisplayShortest()
+}
+
+// sameModel reports whether two model names refer to the same model and tag.
+func sameModel(a string, b string) bool {
+	return strings.EqualFold(normalizeModelName(a), normalizeModelName(b))
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSameModel(t *testing.T) {
+	tt := []struct {
+		testName string
+		a        string
+		b        string
+		expected bool
+	}{
+		{"identical", "gemma3:27b", "gemma3:27b", true},
+		{"implicit latest", "gemma3", "gemma3:latest", true},
+		{"different tag", "gemma3", "gemma3:27b", false},
+		{"fully qualified", "registry.ollama.ai/library/gemma3:4b", "gemma3:4b", true},
+		{"namespace", "hofer/gemma3:4b", "gemma3:4b", false},
+		{"case insensitive", "Gemma3:4B", "gemma3:4b", true},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			assert.Equal(t, td.expected, sameModel(td.a, td.b))
+		})
+	}
+}
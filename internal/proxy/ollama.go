@@ -3,19 +3,23 @@ package proxy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/charmbracelet/huh/spinner"
+	"github.com/hofer/nats-llm/pkq/llm"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 	"github.com/ollama/ollama/api"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 	"net/http"
 	"net/url"
 	"runtime"
 	"strings"
+	"time"
 )
 
-func StartOllamaProxy(natsUrl string, ollamaUrl string) error {
+func StartOllamaProxy(natsUrl string, ollamaUrl string, config OllamaProxyConfig) error {
 	nc, err := nats.Connect(natsUrl)
 	if err != nil {
 		return err
@@ -27,7 +31,7 @@ func StartOllamaProxy(natsUrl string, ollamaUrl string) error {
 	}
 
 	client := api.NewClient(parsedUrl, http.DefaultClient)
-	natsOllamaProxy := NewNatsOllamaProxy(client)
+	natsOllamaProxy := NewNatsOllamaProxy(client, config)
 	err = natsOllamaProxy.Start(nc)
 	if err != nil {
 		return err
@@ -37,22 +41,59 @@ func StartOllamaProxy(natsUrl string, ollamaUrl string) error {
 	return nil
 }
 
+// OllamaProxyConfig holds the settings of a NatsOllamaProxy.
+type OllamaProxyConfig struct {
+	// Models restricts the models clients may use. A nil filter allows all models.
+	Models *ModelFilter
+
+	// AllowPull enables automatic pulling of models which are not available locally.
+	AllowPull bool
+
+	// PullTimeout limits how long an automatic pull of a model may take. Zero means no limit.
+	PullTimeout time.Duration
+
+	// AdminToken must be sent in the AdminTokenHeader to use the model management endpoints and to
+	// list sessions. These endpoints are disabled if no token is set.
+	AdminToken string
+
+	// Sessions enables chat sessions stored in NATS KV, which expire after SessionTTL without updates.
+	Sessions   bool
+	SessionTTL time.Duration
+
+	// SummaryModel summarizes the history of chat requests using the "summarize" truncation strategy.
+	// Without a summary model the model of the request is used.
+	SummaryModel string
+}
+
+var (
+	errPullDisabled = errors.New("automatic pulling of models is disabled on this proxy")
+	errPullTimeout  = errors.New("pull timeout exceeded")
+)
+
 type NatsOllamaProxy struct {
-	client *api.Client
+	client   *api.Client
+	config   OllamaProxyConfig
+	nc       *nats.Conn
+	pulls    singleflight.Group
+	sessions *sessionStore
+	metrics  handlerMetrics
 }
 
-func NewNatsOllamaProxy(client *api.Client) *NatsOllamaProxy {
+func NewNatsOllamaProxy(client *api.Client, config OllamaProxyConfig) *NatsOllamaProxy {
 	return &NatsOllamaProxy{
 		client: client,
+		config: config,
 	}
 }
 
 func (n *NatsOllamaProxy) Start(nc *nats.Conn) error {
 	log.Infof("Starting nats-ollama-proxy...")
+	n.nc = nc
 	srv, err := micro.AddService(nc, micro.Config{
-		Name:        "NatsOllama",
-		Version:     "0.0.1",
-		Description: "Nats microservice acting as a proxy for Ollama.",
+		Name:         "NatsOllama",
+		Version:      "0.0.1",
+		Description:  "Nats microservice acting as a proxy for Ollama.",
+		StatsHandler: n.metrics.stats,
 	})
 	if err != nil {
 		return err
@@ -66,7 +107,7 @@ func (n *NatsOllamaProxy) Start(nc *nats.Conn) error {
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = root.AddEndpoint("generate", micro.HandlerFunc(n.generateHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("generate", n.metrics.recoverHandler(n.generateHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": generateSchema,
 	}))
 	if err != nil {
@@ -75,7 +116,7 @@ func (n *NatsOllamaProxy) Start(nc *nats.Conn) error {
 
 	// Embed
 	embedSchema, err := GetSchemaEmbed()
-	err = root.AddEndpoint("embed", micro.HandlerFunc(n.embedHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("embed", n.metrics.recoverHandler(n.embedHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": embedSchema,
 	}))
 	if err != nil {
@@ -84,7 +125,7 @@ func (n *NatsOllamaProxy) Start(nc *nats.Conn) error {
 
 	// Embedding
 	embeddingSchema, err := GetSchemaEmbedding()
-	err = root.AddEndpoint("embedding", micro.HandlerFunc(n.embeddingHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("embedding", n.metrics.recoverHandler(n.embeddingHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": embeddingSchema,
 	}))
 	if err != nil {
@@ -93,7 +134,7 @@ func (n *NatsOllamaProxy) Start(nc *nats.Conn) error {
 
 	// Chat
 	chatSchema, err := GetSchemaChat()
-	err = root.AddEndpoint("chat", micro.HandlerFunc(n.chatHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("chat", n.metrics.recoverHandler(n.chatHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": chatSchema,
 	}))
 	if err != nil {
@@ -102,10 +143,32 @@ func (n *NatsOllamaProxy) Start(nc *nats.Conn) error {
 
 	// Show
 	showSchema, err := GetSchemaShow()
-	err = root.AddEndpoint("show", micro.HandlerFunc(n.showHandler), micro.WithEndpointMetadata(map[string]string{
+	err = root.AddEndpoint("show", n.metrics.recoverHandler(n.showHandler), micro.WithEndpointMetadata(map[string]string{
 		"schema": showSchema,
 	}))
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Count tokens
+	countTokensSchema, err := GetSchemaCountTokens()
+	err = root.AddEndpoint("count_tokens", n.metrics.recoverHandler(n.countTokensHandler), micro.WithEndpointMetadata(map[string]string{
+		"schema": countTokensSchema,
+	}))
+	if err != nil {
+		return err
+	}
+
+	// Sessions
+	if n.config.Sessions {
+		n.sessions, err = startSessions(context.Background(), nc, srv, n.config.SessionTTL, n.config.AdminToken, &n.metrics)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Model management
+	return n.addAdminEndpoints(root)
 }
 
 func (n *NatsOllamaProxy) generateHandler(req micro.Request) {
@@ -116,6 +179,11 @@ func (n *NatsOllamaProxy) generateHandler(req micro.Request) {
 		return
 	}
 
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
 	// Set streaming to false, thus making sure we wait for a response.
 	reqData.Stream = new(bool)
 
@@ -147,10 +215,15 @@ func (n *NatsOllamaProxy) embedHandler(req micro.Request) {
 
 	log.Infof("Embed Request for model: '%s'", reqData.Model)
 
-	err = n.pullMissingModel(err, reqData.Model)
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	err = n.pullMissingModel(reqData.Model)
 	if err != nil {
 		log.Error("Error when checking/pulling a missing model:", err)
-		req.Error("500", err.Error(), nil)
+		req.Error(pullErrorCode(err), err.Error(), nil)
 		return
 	}
 
@@ -179,6 +252,11 @@ func (n *NatsOllamaProxy) embeddingHandler(req micro.Request) {
 		return
 	}
 
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
 	ctx := context.Background()
 	resp, err := n.client.Embeddings(ctx, &reqData)
 	if err != nil {
@@ -206,25 +284,67 @@ func (n *NatsOllamaProxy) chatHandler(req micro.Request) {
 	// Set streaming to false, thus making sure we wait for a response.
 	reqData.Stream = new(bool)
 
+	session, err := n.sessions.prepareChat(context.Background(), req, &reqData)
+	if err != nil {
+		req.Error(sessionErrorCode(err), err.Error(), nil)
+		return
+	}
+
 	log.Infof("Chat request for model: '%s'", reqData.Model)
+	responseHeaders := micro.Headers{}
 	respFunc := func(resp api.ChatResponse) error {
+		err := session.save(context.Background(), resp.Message)
+		if err != nil {
+			log.Error("Error saving session:", err)
+			req.Error("500", fmt.Sprintf("cannot save the session: %v", err), nil)
+			return err
+		}
+
 		responseData, err := json.Marshal(resp)
 		if err != nil {
 			log.Error("Error marshalling response:", err)
 			req.Error("400", err.Error(), nil)
 			return err
 		}
-		err = req.Respond(responseData)
+		err = req.Respond(responseData, micro.WithHeaders(responseHeaders))
 		return err
 	}
 
-	err = n.pullMissingModel(err, reqData.Model)
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	strategy, err := parseTruncationStrategy(req.Headers().Get(TruncateHeader))
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	err = n.pullMissingModel(reqData.Model)
 	if err != nil {
 		log.Error("Error when checking/pulling a missing model:", err)
-		req.Error("500", err.Error(), nil)
+		req.Error(pullErrorCode(err), err.Error(), nil)
 		return
 	}
 
+	if strategy != nil {
+		budget, err := n.contextBudget(context.Background(), reqData)
+		if err != nil {
+			req.Error("500", err.Error(), nil)
+			return
+		}
+		truncated, err := truncateChat(context.Background(), &reqData, strategy, budget, n.summarizer(reqData.Model))
+		if err != nil {
+			req.Error("500", err.Error(), nil)
+			return
+		}
+		if truncated != nil {
+			log.Infof("Chat request for model '%s': truncated the history (%s)", reqData.Model, truncated)
+			responseHeaders[TruncatedHeader] = []string{truncated.String()}
+		}
+	}
+
 	ctxChat := context.Background()
 	var chatError error
 	sp := spinner.New()
@@ -232,7 +352,7 @@ func (n *NatsOllamaProxy) chatHandler(req micro.Request) {
 		chatError = n.client.Chat(ctxChat, &reqData, respFunc)
 	}
 
-	err = sp.Title(fmt.Sprintf("Processing chat request for model '%s'...", reqData.Model)).Action(action).Run()
+	err = runSpinner(sp.Title(fmt.Sprintf("Processing chat request for model '%s'...", reqData.Model)), action)
 
 	//err = n.client.Chat(ctx, &reqData, respFunc)
 	if chatError != nil {
@@ -245,6 +365,121 @@ func (n *NatsOllamaProxy) chatHandler(req micro.Request) {
 	}
 }
 
+// countTokensHandler counts the prompt tokens of a chat request. Ollama has no endpoint to count tokens,
+// thus the request is evaluated with num_predict 1 and its prompt_eval_count is returned. The count is
+// only an estimate: if a previous request shares a prefix with the prompt, the cached part is not
+// evaluated again and not counted. Missing models are not pulled, as counting tokens should not download
+// a model as a side effect.
+func (n *NatsOllamaProxy) countTokensHandler(req micro.Request) {
+	var reqData api.ChatRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	reqData.Stream = new(bool)
+	if reqData.Options == nil {
+		reqData.Options = map[string]any{}
+	}
+	reqData.Options["num_predict"] = 1
+
+	promptTokens := 0
+	err = n.client.Chat(context.Background(), &reqData, func(resp api.ChatResponse) error {
+		promptTokens = resp.PromptEvalCount
+		return nil
+	})
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		req.Error("404", err.Error(), nil)
+		return
+	}
+	if err != nil {
+		log.Error("Error counting tokens:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+
+	resp := llm.CountTokensResponse{
+		Model:       reqData.Model,
+		TotalTokens: promptTokens,
+		Estimated:   true,
+	}
+	if promptTokens == 0 {
+		resp.TotalTokens = estimateMessageTokens(reqData.Messages...) + jsonLength(reqData.Tools)/4
+	}
+	respondJSON(req, resp)
+}
+
+// contextBudget returns the number of tokens available for the messages of a chat request: the context
+// size of the request (num_ctx) or the context length of the model, without the tokens to predict.
+func (n *NatsOllamaProxy) contextBudget(ctx context.Context, reqData api.ChatRequest) (int, error) {
+	contextLength := 0
+	if numCtx, ok := reqData.Options["num_ctx"]; ok {
+		value, err := int32Option("num_ctx", numCtx)
+		if err != nil {
+			return 0, err
+		}
+		contextLength = int(*value)
+	} else {
+		show, err := n.client.Show(ctx, &api.ShowRequest{Model: reqData.Model})
+		if err != nil {
+			return 0, fmt.Errorf("cannot read the context length of model '%s': %w", reqData.Model, err)
+		}
+		contextLength = modelContextLength(show.ModelInfo)
+		if contextLength <= 0 {
+			return 0, fmt.Errorf("the context length of model '%s' is unknown", reqData.Model)
+		}
+	}
+
+	if numPredict, ok := reqData.Options["num_predict"]; ok {
+		value, err := int32Option("num_predict", numPredict)
+		if err == nil && *value > 0 {
+			contextLength -= int(*value)
+		}
+	}
+	return contextLength, nil
+}
+
+// modelContextLength reads the context length from the model info of a show response, which is stored
+// with the architecture as prefix, e.g. "gemma3.context_length".
+func modelContextLength(modelInfo map[string]any) int {
+	for key, value := range modelInfo {
+		if !strings.HasSuffix(key, ".context_length") {
+			continue
+		}
+		length, err := float64Option(key, value)
+		if err == nil {
+			return int(length)
+		}
+	}
+	return 0
+}
+
+// summarizer summarizes messages with the configured summary model or the given model of the request.
+func (n *NatsOllamaProxy) summarizer(model string) summarizeFunc {
+	if n.config.SummaryModel != "" {
+		model = n.config.SummaryModel
+	}
+	return func(ctx context.Context, messages []api.Message) (string, error) {
+		var summary string
+		err := n.client.Chat(ctx, &api.ChatRequest{
+			Model:    model,
+			Messages: []api.Message{{Role: "user", Content: summaryTranscript(messages)}},
+			Stream:   new(bool),
+		}, func(resp api.ChatResponse) error {
+			summary += resp.Message.Content
+			return nil
+		})
+		return summary, err
+	}
+}
+
 func (n *NatsOllamaProxy) showHandler(req micro.Request) {
 	var reqData api.ShowRequest
 	err := json.Unmarshal(req.Data(), &reqData)
@@ -254,10 +489,15 @@ func (n *NatsOllamaProxy) showHandler(req micro.Request) {
 		return
 	}
 
-	err = n.pullMissingModel(err, reqData.Model)
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	err = n.pullMissingModel(reqData.Model)
 	if err != nil {
 		log.Error("Error when checking/pulling a missing model:", err)
-		req.Error("500", err.Error(), nil)
+		req.Error(pullErrorCode(err), err.Error(), nil)
 		return
 	}
 
@@ -269,7 +509,7 @@ func (n *NatsOllamaProxy) showHandler(req micro.Request) {
 		resp, showError = n.client.Show(ctxShow, &reqData)
 	}
 
-	err = sp.Title(fmt.Sprintf("Processing show request for model '%s'...", reqData.Model)).Action(action).Run()
+	err = runSpinner(sp.Title(fmt.Sprintf("Processing show request for model '%s'...", reqData.Model)), action)
 	if showError != nil {
 		log.Error("Error on show response:", showError)
 		req.Error("400", showError.Error(), nil)
@@ -297,7 +537,7 @@ func (n *NatsOllamaProxy) showHandler(req micro.Request) {
 	return
 }
 
-func (n *NatsOllamaProxy) pullMissingModel(err error, model string) error {
+func (n *NatsOllamaProxy) pullMissingModel(model string) error {
 	ctx := context.Background()
 	modelList, err := n.client.List(ctx)
 	if err != nil {
@@ -305,13 +545,35 @@ func (n *NatsOllamaProxy) pullMissingModel(err error, model string) error {
 	}
 
 	for _, ml := range modelList.Models {
-		if strings.HasPrefix(ml.Model, model) {
+		if sameModel(ml.Model, model) {
 			return nil
 		}
 	}
 
+	if !n.config.AllowPull {
+		return fmt.Errorf("model '%s' is not available: %w", model, errPullDisabled)
+	}
+
+	// Concurrent requests for the same missing model all wait on a single pull:
+	_, err, shared := n.pulls.Do(normalizeModelName(model), func() (any, error) {
+		return nil, n.pullModel(model)
+	})
+	if shared {
+		log.Debugf("Request for model '%s' waited on a concurrent pull", model)
+	}
+	return err
+}
+
+func (n *NatsOllamaProxy) pullModel(model string) error {
 	ctxPull := context.Background()
+	if n.config.PullTimeout > 0 {
+		var cancel context.CancelFunc
+		ctxPull, cancel = context.WithTimeout(ctxPull, n.config.PullTimeout)
+		defer cancel()
+	}
+
 	log.Warningf("Model does not exist. Start pulling a new model: '%s'", model)
+	var err error
 	sp := spinner.New()
 	action := func() {
 		err = n.client.Pull(ctxPull, &api.PullRequest{Model: model}, func(response api.ProgressResponse) error {
@@ -322,12 +584,25 @@ func (n *NatsOllamaProxy) pullMissingModel(err error, model string) error {
 		})
 	}
 
-	sp.Title(fmt.Sprintf("Downloading model '%s'...", model)).Action(action).Run()
+	runSpinner(sp.Title(fmt.Sprintf("Downloading model '%s'...", model)), action)
 
+	if errors.Is(ctxPull.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("pulling model '%s' did not finish within %s: %w", model, n.config.PullTimeout, errPullTimeout)
+	}
 	if err != nil {
-		return err
+		return fmt.Errorf("pulling model '%s' failed: %w", model, err)
 	}
 	log.Infof("Pulling of model '%s' complete.", model)
 
 	return nil
 }
+
+func pullErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errPullDisabled):
+		return "404"
+	case errors.Is(err, errPullTimeout):
+		return "504"
+	}
+	return "500"
+}
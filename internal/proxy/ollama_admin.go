@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// OllamaPullRequest is an api.PullRequest which optionally publishes progress events to a NATS subject.
+type OllamaPullRequest struct {
+	api.PullRequest
+	ProgressSubject string `json:"progress_subject,omitempty"`
+}
+
+// OllamaCreateRequest is an api.CreateRequest which optionally publishes progress events to a NATS subject.
+type OllamaCreateRequest struct {
+	api.CreateRequest
+	ProgressSubject string `json:"progress_subject,omitempty"`
+}
+
+func (n *NatsOllamaProxy) addAdminEndpoints(root micro.Group) error {
+	endpoints := []struct {
+		name    string
+		schema  func() (string, error)
+		handler micro.HandlerFunc
+	}{
+		{"list", GetSchemaList, n.listHandler},
+		{"ps", GetSchemaPs, n.psHandler},
+		{"pull", GetSchemaPull, n.pullHandler},
+		{"delete", GetSchemaDelete, n.deleteHandler},
+		{"copy", GetSchemaCopy, n.copyHandler},
+		{"create", GetSchemaCreate, n.createHandler},
+	}
+
+	for _, endpoint := range endpoints {
+		endpointSchema, err := endpoint.schema()
+		if err != nil {
+			return err
+		}
+		err = root.AddEndpoint(endpoint.name, n.metrics.recoverHandler(n.requireAdmin(endpoint.handler)), micro.WithEndpointMetadata(map[string]string{
+			"schema": endpointSchema,
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requireAdmin only passes requests on to the given handler if they carry the configured admin token.
+func (n *NatsOllamaProxy) requireAdmin(handler micro.HandlerFunc) micro.HandlerFunc {
+	return requireAdminToken(n.config.AdminToken, handler)
+}
+
+// requireAdminToken only passes requests on to the given handler if they carry the admin token. Without
+// a token the handler is disabled.
+func requireAdminToken(adminToken string, handler micro.HandlerFunc) micro.HandlerFunc {
+	return func(req micro.Request) {
+		if adminToken == "" {
+			req.Error("403", "this endpoint requires an admin token, but none is configured on this proxy", nil)
+			return
+		}
+
+		token := req.Headers().Get(AdminTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			log.Warningf("Rejected unauthorized request on '%s'", req.Subject())
+			req.Error("403", "missing or invalid admin token", nil)
+			return
+		}
+		handler(req)
+	}
+}
+
+func (n *NatsOllamaProxy) listHandler(req micro.Request) {
+	resp, err := n.client.List(context.Background())
+	if err != nil {
+		log.Error("Error listing models:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, resp)
+}
+
+func (n *NatsOllamaProxy) psHandler(req micro.Request) {
+	resp, err := n.client.ListRunning(context.Background())
+	if err != nil {
+		log.Error("Error listing running models:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, resp)
+}
+
+func (n *NatsOllamaProxy) pullHandler(req micro.Request) {
+	var reqData OllamaPullRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	log.Infof("Pull request for model: '%s'", reqData.Model)
+	reqData.Stream = nil
+	err = n.client.Pull(context.Background(), &reqData.PullRequest, n.publishProgress(reqData.ProgressSubject))
+	if err != nil {
+		log.Error("Error pulling model:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, api.ProgressResponse{Status: "success"})
+}
+
+func (n *NatsOllamaProxy) deleteHandler(req micro.Request) {
+	var reqData api.DeleteRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	log.Infof("Delete request for model: '%s'", reqData.Model)
+	err = n.client.Delete(context.Background(), &reqData)
+	if err != nil {
+		log.Error("Error deleting model:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, api.ProgressResponse{Status: "success"})
+}
+
+func (n *NatsOllamaProxy) copyHandler(req micro.Request) {
+	var reqData api.CopyRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	// Both models are checked, otherwise denied models could be copied to an allowed name:
+	for _, model := range []string{reqData.Source, reqData.Destination} {
+		if !n.config.Models.Allowed(model) {
+			req.Error("403", modelNotAllowedError(model).Error(), nil)
+			return
+		}
+	}
+
+	log.Infof("Copy request from model '%s' to '%s'", reqData.Source, reqData.Destination)
+	err = n.client.Copy(context.Background(), &reqData)
+	if err != nil {
+		log.Error("Error copying model:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, api.ProgressResponse{Status: "success"})
+}
+
+func (n *NatsOllamaProxy) createHandler(req micro.Request) {
+	var reqData OllamaCreateRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	if !n.config.Models.Allowed(reqData.Model) {
+		req.Error("403", modelNotAllowedError(reqData.Model).Error(), nil)
+		return
+	}
+
+	log.Infof("Create request for model: '%s'", reqData.Model)
+	reqData.Stream = nil
+	err = n.client.Create(context.Background(), &reqData.CreateRequest, n.publishProgress(reqData.ProgressSubject))
+	if err != nil {
+		log.Error("Error creating model:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, api.ProgressResponse{Status: "success"})
+}
+
+// progressPublisher publishes progress events, it is implemented by *nats.Conn.
+type progressPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// publishProgress returns a progress function publishing each event to the given subject. Events
+// are discarded if no subject is given.
+func (n *NatsOllamaProxy) publishProgress(subject string) func(api.ProgressResponse) error {
+	return publishProgressTo(n.nc, subject)
+}
+
+func publishProgressTo(nc progressPublisher, subject string) func(api.ProgressResponse) error {
+	return func(progress api.ProgressResponse) error {
+		if subject == "" {
+			return nil
+		}
+
+		progressData, err := json.Marshal(progress)
+		if err != nil {
+			return err
+		}
+		if err = nc.Publish(subject, progressData); err != nil {
+			log.Warningf("Cannot publish progress to '%s': %v", subject, err)
+		}
+		return nil
+	}
+}
+
+func respondJSON(req micro.Request, resp any) {
+	responseData, err := json.Marshal(resp)
+	if err != nil {
+		log.Error("Error marshalling response:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+
+	err = req.Respond(responseData)
+	if err != nil {
+		log.Error("Error sending response:", err)
+	}
+}
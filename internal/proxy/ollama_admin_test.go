@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"encoding/json"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	tt := []struct {
+		testName   string
+		adminToken string
+		headers    micro.Headers
+		handled    bool
+		errorCode  string
+	}{
+		{
+			testName:  "no admin token configured",
+			headers:   micro.Headers{AdminTokenHeader: []string{""}},
+			errorCode: "403",
+		},
+		{
+			testName:   "missing token",
+			adminToken: "secret",
+			errorCode:  "403",
+		},
+		{
+			testName:   "wrong token",
+			adminToken: "secret",
+			headers:    micro.Headers{AdminTokenHeader: []string{"guess"}},
+			errorCode:  "403",
+		},
+		{
+			testName:   "valid token",
+			adminToken: "secret",
+			headers:    micro.Headers{AdminTokenHeader: []string{"secret"}},
+			handled:    true,
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			proxy := NewNatsOllamaProxy(nil, OllamaProxyConfig{AdminToken: td.adminToken})
+			handled := false
+			handler := proxy.requireAdmin(func(req micro.Request) {
+				handled = true
+			})
+
+			req := &headerRequest{headers: td.headers}
+			handler(req)
+
+			assert.Equal(t, td.handled, handled)
+			assert.Equal(t, td.errorCode, req.errorCode)
+		})
+	}
+}
+
+func TestAdminHandlersModelNotAllowed(t *testing.T) {
+	models, err := NewModelFilter([]string{"gemma3:*"}, []string{"gemma3:27b"})
+	assert.NoError(t, err)
+	proxy := NewNatsOllamaProxy(nil, OllamaProxyConfig{Models: models})
+
+	tt := []struct {
+		testName string
+		handler  micro.HandlerFunc
+		inData   string
+	}{
+		{testName: "delete", handler: proxy.deleteHandler, inData: `{"model": "llama3:8b"}`},
+		{testName: "copy to a denied model", handler: proxy.copyHandler, inData: `{"source": "gemma3:4b", "destination": "llama3:8b"}`},
+		{testName: "copy from a denied model", handler: proxy.copyHandler, inData: `{"source": "gemma3:27b", "destination": "gemma3:copy"}`},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			req := &headerRequest{data: []byte(td.inData)}
+			td.handler(req)
+
+			assert.Equal(t, "403", req.errorCode)
+		})
+	}
+}
+
+type recordingPublisher struct {
+	subjects []string
+	events   []api.ProgressResponse
+}
+
+func (p *recordingPublisher) Publish(subject string, data []byte) error {
+	var progress api.ProgressResponse
+	if err := json.Unmarshal(data, &progress); err != nil {
+		return err
+	}
+	p.subjects = append(p.subjects, subject)
+	p.events = append(p.events, progress)
+	return nil
+}
+
+func TestPublishProgress(t *testing.T) {
+	tt := []struct {
+		testName string
+		subject  string
+		expected []string
+	}{
+		{
+			testName: "no subject",
+			subject:  "",
+		},
+		{
+			testName: "progress subject",
+			subject:  "progress.pull.gemma3",
+			expected: []string{"progress.pull.gemma3", "progress.pull.gemma3"},
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			publisher := &recordingPublisher{}
+			progress := publishProgressTo(publisher, td.subject)
+
+			assert.NoError(t, progress(api.ProgressResponse{Status: "pulling manifest"}))
+			assert.NoError(t, progress(api.ProgressResponse{Status: "success"}))
+
+			assert.Equal(t, td.expected, publisher.subjects)
+			if td.expected != nil {
+				assert.Equal(t, "success", publisher.events[1].Status)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"encoding/json"
+	"github.com/hofer/nats-llm/pkq/llm"
 	"github.com/invopop/jsonschema"
 	"github.com/ollama/ollama/api"
 )
@@ -56,3 +57,31 @@ func GetSchemaChat() (string, error) {
 func GetSchemaShow() (string, error) {
 	return marshalSchema(&api.ShowRequest{}, &api.ShowResponse{})
 }
+
+func GetSchemaList() (string, error) {
+	return marshalSchema(&struct{}{}, &api.ListResponse{})
+}
+
+func GetSchemaPs() (string, error) {
+	return marshalSchema(&struct{}{}, &api.ProcessResponse{})
+}
+
+func GetSchemaPull() (string, error) {
+	return marshalSchema(&OllamaPullRequest{}, &api.ProgressResponse{})
+}
+
+func GetSchemaDelete() (string, error) {
+	return marshalSchema(&api.DeleteRequest{}, &api.ProgressResponse{})
+}
+
+func GetSchemaCopy() (string, error) {
+	return marshalSchema(&api.CopyRequest{}, &api.ProgressResponse{})
+}
+
+func GetSchemaCreate() (string, error) {
+	return marshalSchema(&OllamaCreateRequest{}, &api.ProgressResponse{})
+}
+
+func GetSchemaCountTokens() (string, error) {
+	return marshalSchema(&api.ChatRequest{}, &llm.CountTokensResponse{})
+}
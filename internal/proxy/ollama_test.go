@@ -1,5 +1,16 @@
 package proxy
 
+import (
+	"encoding/json"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
 //
 //func TestOllamaClientShow(t *testing.T) {
 //	client, _ := api.ClientFromEnvironment()
@@ -28,3 +39,61 @@ package proxy
 //		fmt.Println(capability)
 //	}
 //}
+
+func TestCountTokensHandler(t *testing.T) {
+	tt := []struct {
+		testName      string
+		inStatus      int
+		inResponse    string
+		expected      llm.CountTokensResponse
+		expectedError string
+	}{
+		{
+			testName:   "prompt evaluated",
+			inStatus:   http.StatusOK,
+			inResponse: `{"model": "gemma3:4b", "done": true, "prompt_eval_count": 26}`,
+			expected:   llm.CountTokensResponse{Model: "gemma3:4b", TotalTokens: 26, Estimated: true},
+		},
+		{
+			testName:   "prompt cached completely",
+			inStatus:   http.StatusOK,
+			inResponse: `{"model": "gemma3:4b", "done": true}`,
+			expected:   llm.CountTokensResponse{Model: "gemma3:4b", TotalTokens: 2, Estimated: true},
+		},
+		{
+			testName:      "missing model",
+			inStatus:      http.StatusNotFound,
+			inResponse:    `{"error": "model 'gemma3:4b' not found"}`,
+			expectedError: "404",
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			var paths []string
+			var chatReq api.ChatRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				json.NewDecoder(r.Body).Decode(&chatReq)
+				w.WriteHeader(td.inStatus)
+				w.Write([]byte(td.inResponse))
+			}))
+			defer server.Close()
+			serverUrl, _ := url.Parse(server.URL)
+			proxy := NewNatsOllamaProxy(api.NewClient(serverUrl, server.Client()), OllamaProxyConfig{AllowPull: true})
+
+			req := &headerRequest{data: []byte(`{"model": "gemma3:4b", "messages": [{"role": "user", "content": "Hello"}]}`)}
+			proxy.countTokensHandler(req)
+
+			// Neither the models are listed nor a missing model is pulled:
+			assert.Equal(t, []string{"/api/chat"}, paths)
+			assert.Equal(t, float64(1), chatReq.Options["num_predict"])
+			assert.Equal(t, td.expectedError, req.errorCode)
+			if td.expectedError == "" {
+				var resp llm.CountTokensResponse
+				assert.NoError(t, json.Unmarshal(req.response, &resp))
+				assert.Equal(t, td.expected, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/ollama/ollama/api"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// SessionBucket is the NATS KV bucket holding the sessions of all proxies.
+const SessionBucket = "nats_llm_sessions"
+
+// maxSessionUpdateAttempts limits the retries of a session update conflicting with concurrent updates.
+const maxSessionUpdateAttempts = 3
+
+var (
+	errSessionsDisabled = errors.New("sessions are disabled on this proxy")
+	errSessionNotFound  = errors.New("session not found")
+)
+
+// sessionStore keeps the sessions in a NATS KV bucket, so they are shared by all proxy instances.
+type sessionStore struct {
+	kv jetstream.KeyValue
+}
+
+// newSessionStore creates or binds the session bucket. Sessions expire after the given TTL without
+// updates, a TTL of zero keeps them until they are deleted.
+func newSessionStore(ctx context.Context, nc *nats.Conn, ttl time.Duration) (*sessionStore, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+
+	kv, err := js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      SessionBucket,
+		Description: "Chat sessions of nats-llm",
+		TTL:         ttl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create the KV bucket '%s': %w", SessionBucket, err)
+	}
+	return &sessionStore{kv: kv}, nil
+}
+
+// addSessionEndpoints registers the session endpoints. They are answered by any proxy with sessions
+// enabled, as all proxies share the same bucket. The session ID is the only secret needed to read a
+// session, so anyone holding it may also delete the session. Only listing sessions, which reveals
+// their IDs, requires the admin token.
+func addSessionEndpoints(srv micro.Service, sessions *sessionStore, adminToken string, metrics *handlerMetrics) error {
+	if sessions == nil {
+		return nil
+	}
+
+	group := srv.AddGroup("session")
+	endpoints := []struct {
+		name    string
+		schema  func() (string, error)
+		handler micro.HandlerFunc
+	}{
+		{"create", GetSchemaSessionCreate, sessions.createHandler},
+		{"get", GetSchemaSessionGet, sessions.getHandler},
+		{"delete", GetSchemaSessionDelete, sessions.deleteHandler},
+		{"list", GetSchemaSessionList, requireAdminToken(adminToken, sessions.listHandler)},
+	}
+
+	for _, endpoint := range endpoints {
+		endpointSchema, err := endpoint.schema()
+		if err != nil {
+			return err
+		}
+		err = group.AddEndpoint(endpoint.name, metrics.recoverHandler(endpoint.handler), micro.WithEndpointMetadata(map[string]string{
+			"schema": endpointSchema,
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sessionStore) createHandler(req micro.Request) {
+	var reqData llm.SessionCreateRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	now := time.Now()
+	session := llm.Session{
+		ID:        newSessionID(),
+		Model:     reqData.Model,
+		Messages:  reqData.Messages,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	value, err := json.Marshal(session)
+	if err != nil {
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	_, err = s.kv.Create(context.Background(), session.ID, value)
+	if err != nil {
+		log.Error("Error creating session:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, session)
+}
+
+// newSessionID returns a random session ID. Unlike NUIDs, it cannot be guessed from other session IDs.
+func newSessionID() string {
+	return rand.Text()
+}
+
+func (s *sessionStore) getHandler(req micro.Request) {
+	var reqData llm.SessionRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	session, _, err := s.get(context.Background(), reqData.ID)
+	if err != nil {
+		req.Error(sessionErrorCode(err), err.Error(), nil)
+		return
+	}
+	respondJSON(req, session)
+}
+
+func (s *sessionStore) deleteHandler(req micro.Request) {
+	var reqData llm.SessionRequest
+	err := json.Unmarshal(req.Data(), &reqData)
+	if err != nil {
+		req.Error("400", err.Error(), nil)
+		return
+	}
+
+	ctx := context.Background()
+	session, _, err := s.get(ctx, reqData.ID)
+	if err != nil {
+		req.Error(sessionErrorCode(err), err.Error(), nil)
+		return
+	}
+	err = s.kv.Purge(ctx, reqData.ID)
+	if err != nil {
+		log.Error("Error deleting session:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+	respondJSON(req, session)
+}
+
+func (s *sessionStore) listHandler(req micro.Request) {
+	ctx := context.Background()
+	keys, err := s.kv.ListKeys(ctx)
+	if err != nil {
+		log.Error("Error listing sessions:", err)
+		req.Error("500", err.Error(), nil)
+		return
+	}
+
+	resp := llm.SessionListResponse{Sessions: []llm.Session{}}
+	for key := range keys.Keys() {
+		session, _, err := s.get(ctx, key)
+		if err != nil {
+			// The session was deleted or expired while listing:
+			continue
+		}
+		session.Messages = nil
+		resp.Sessions = append(resp.Sessions, session)
+	}
+	respondJSON(req, resp)
+}
+
+// get loads a session and the revision it was loaded at.
+func (s *sessionStore) get(ctx context.Context, id string) (llm.Session, uint64, error) {
+	entry, err := s.kv.Get(ctx, id)
+	if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrKeyDeleted) {
+		return llm.Session{}, 0, fmt.Errorf("%w: '%s'", errSessionNotFound, id)
+	}
+	if err != nil {
+		return llm.Session{}, 0, err
+	}
+
+	var session llm.Session
+	err = json.Unmarshal(entry.Value(), &session)
+	if err != nil {
+		return llm.Session{}, 0, err
+	}
+	return session, entry.Revision(), nil
+}
+
+// sessionChat is a chat request sent within a session.
+type sessionChat struct {
+	store       *sessionStore
+	session     llm.Session
+	revision    uint64
+	newMessages []api.Message
+}
+
+// prepareChat loads the session selected by the header of a chat request and prepends its history to the
+// messages of the request. It returns nil if the request is not sent within a session.
+func (s *sessionStore) prepareChat(ctx context.Context, req micro.Request, reqData *api.ChatRequest) (*sessionChat, error) {
+	sessionID := req.Headers().Get(llm.SessionIDHeader)
+	if sessionID == "" {
+		return nil, nil
+	}
+	if s == nil {
+		return nil, errSessionsDisabled
+	}
+
+	session, revision, err := s.get(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	chat := &sessionChat{
+		store:       s,
+		session:     session,
+		revision:    revision,
+		newMessages: reqData.Messages,
+	}
+	if reqData.Model == "" {
+		reqData.Model = session.Model
+	}
+	reqData.Messages = append(append([]api.Message{}, session.Messages...), reqData.Messages...)
+	return chat, nil
+}
+
+// save appends the new messages of the request and the response to the session. Updates of the session
+// made concurrently by other requests are kept.
+func (c *sessionChat) save(ctx context.Context, response api.Message) error {
+	if c == nil {
+		return nil
+	}
+
+	session := c.session
+	revision := c.revision
+	for attempt := 1; ; attempt++ {
+		session.Messages = append(append(session.Messages, c.newMessages...), response)
+		session.UpdatedAt = time.Now()
+		value, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+
+		_, err = c.store.kv.Update(ctx, session.ID, value, revision)
+		if err == nil || !errors.Is(err, jetstream.ErrKeyExists) || attempt == maxSessionUpdateAttempts {
+			return err
+		}
+
+		// The session was updated in the meantime, append to the latest version:
+		session, revision, err = c.store.get(ctx, session.ID)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func sessionErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		return "404"
+	case errors.Is(err, errSessionsDisabled):
+		return "501"
+	case errors.Is(err, jetstream.ErrInvalidKey):
+		return "400"
+	}
+	return "500"
+}
+
+func GetSchemaSessionCreate() (string, error) {
+	return marshalSchema(&llm.SessionCreateRequest{}, &llm.Session{})
+}
+
+func GetSchemaSessionGet() (string, error) {
+	return marshalSchema(&llm.SessionRequest{}, &llm.Session{})
+}
+
+func GetSchemaSessionDelete() (string, error) {
+	return marshalSchema(&llm.SessionRequest{}, &llm.Session{})
+}
+
+func GetSchemaSessionList() (string, error) {
+	return marshalSchema(&llm.ListRequest{}, &llm.SessionListResponse{})
+}
+
+// startSessions creates the session store of a proxy and registers the session endpoints. Without
+// JetStream sessions are disabled and only a warning is logged.
+func startSessions(ctx context.Context, nc *nats.Conn, srv micro.Service, ttl time.Duration, adminToken string, metrics *handlerMetrics) (*sessionStore, error) {
+	sessions, err := newSessionStore(ctx, nc, ttl)
+	if err != nil {
+		log.Warningf("Sessions are disabled: %v", err)
+		return nil, nil
+	}
+	return sessions, addSessionEndpoints(srv, sessions, adminToken, metrics)
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/llm"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+// headerRequest is a request with a subject, headers and data, recording the response or error of a handler.
+type headerRequest struct {
+	DummyRequest
+	subject   string
+	headers   micro.Headers
+	data      []byte
+	response  []byte
+	errorCode string
+}
+
+func (r *headerRequest) Subject() string {
+	return r.subject
+}
+
+func (r *headerRequest) Headers() micro.Headers {
+	return r.headers
+}
+
+func (r *headerRequest) Data() []byte {
+	if r.data == nil {
+		return r.DummyRequest.Data()
+	}
+	return r.data
+}
+
+func (r *headerRequest) Respond(data []byte, opts ...micro.RespondOpt) error {
+	r.response = data
+	return nil
+}
+
+func (r *headerRequest) Error(code, description string, data []byte, opts ...micro.RespondOpt) error {
+	r.errorCode = code
+	return nil
+}
+
+// memoryKV is an in memory KV bucket supporting the operations used by the session store.
+type memoryKV struct {
+	jetstream.KeyValue
+	mu        sync.Mutex
+	values    map[string][]byte
+	revisions map[string]uint64
+	revision  uint64
+
+	// conflicts is the number of updates which fail as if the session had been updated concurrently.
+	conflicts int
+}
+
+type memoryEntry struct {
+	jetstream.KeyValueEntry
+	value    []byte
+	revision uint64
+}
+
+func (e *memoryEntry) Value() []byte    { return e.value }
+func (e *memoryEntry) Revision() uint64 { return e.revision }
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{values: map[string][]byte{}, revisions: map[string]uint64{}}
+}
+
+func (kv *memoryKV) Get(ctx context.Context, key string) (jetstream.KeyValueEntry, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok := kv.values[key]
+	if !ok {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return &memoryEntry{value: value, revision: kv.revisions[key]}, nil
+}
+
+func (kv *memoryKV) Create(ctx context.Context, key string, value []byte, opts ...jetstream.KVCreateOpt) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if _, ok := kv.values[key]; ok {
+		return 0, jetstream.ErrKeyExists
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *memoryKV) Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.conflicts > 0 {
+		kv.conflicts--
+		kv.put(key, kv.values[key])
+	}
+	if kv.revisions[key] != revision {
+		return 0, fmt.Errorf("%w: wrong last sequence", jetstream.ErrKeyExists)
+	}
+	return kv.put(key, value), nil
+}
+
+func (kv *memoryKV) put(key string, value []byte) uint64 {
+	kv.revision++
+	kv.values[key] = value
+	kv.revisions[key] = kv.revision
+	return kv.revision
+}
+
+func createTestSession(t *testing.T, store *sessionStore) llm.Session {
+	req := &headerRequest{data: []byte(`{"model": "gemma3:27b", "messages": [{"role": "system", "content": "You are a weather bot."}]}`)}
+	store.createHandler(req)
+
+	var session llm.Session
+	assert.Empty(t, req.errorCode)
+	assert.NoError(t, json.Unmarshal(req.response, &session))
+	return session
+}
+
+func sendSessionChat(t *testing.T, store *sessionStore, sessionID string, content string) (*api.ChatRequest, error) {
+	ctx := context.Background()
+	req := &headerRequest{headers: micro.Headers{llm.SessionIDHeader: []string{sessionID}}}
+	reqData := &api.ChatRequest{Messages: []api.Message{{Role: "user", Content: content}}}
+	chat, err := store.prepareChat(ctx, req, reqData)
+	assert.NoError(t, err)
+	return reqData, chat.save(ctx, api.Message{Role: "assistant", Content: "Answer to " + content})
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	store := &sessionStore{kv: newMemoryKV()}
+	session := createTestSession(t, store)
+	assert.NotEmpty(t, session.ID)
+
+	_, err := sendSessionChat(t, store, session.ID, "How warm is it in Bern?")
+	assert.NoError(t, err)
+	reqData, err := sendSessionChat(t, store, session.ID, "And in Zurich?")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "gemma3:27b", reqData.Model)
+	assert.Equal(t, []api.Message{
+		{Role: "system", Content: "You are a weather bot."},
+		{Role: "user", Content: "How warm is it in Bern?"},
+		{Role: "assistant", Content: "Answer to How warm is it in Bern?"},
+		{Role: "user", Content: "And in Zurich?"},
+	}, reqData.Messages)
+
+	stored, _, err := store.get(context.Background(), session.ID)
+	assert.NoError(t, err)
+	assert.Len(t, stored.Messages, 5)
+	assert.Equal(t, "Answer to And in Zurich?", stored.Messages[4].Content)
+}
+
+func TestSessionSaveConflict(t *testing.T) {
+	kv := newMemoryKV()
+	store := &sessionStore{kv: kv}
+	session := createTestSession(t, store)
+
+	// A single concurrent update is merged:
+	kv.conflicts = 1
+	_, err := sendSessionChat(t, store, session.ID, "Hello")
+	assert.NoError(t, err)
+
+	// The update is given up after maxSessionUpdateAttempts conflicts:
+	kv.conflicts = maxSessionUpdateAttempts
+	_, err = sendSessionChat(t, store, session.ID, "Hello again")
+	assert.ErrorIs(t, err, jetstream.ErrKeyExists)
+
+	stored, _, err := store.get(context.Background(), session.ID)
+	assert.NoError(t, err)
+	assert.Len(t, stored.Messages, 3)
+}
+
+func TestPrepareChatWithoutSession(t *testing.T) {
+	var sessions *sessionStore
+	reqData := &api.ChatRequest{Messages: []api.Message{{Role: "user", Content: "Hello"}}}
+
+	chat, err := sessions.prepareChat(context.Background(), &headerRequest{}, reqData)
+
+	assert.NoError(t, err)
+	assert.Nil(t, chat)
+	assert.Len(t, reqData.Messages, 1)
+	assert.NoError(t, chat.save(context.Background(), api.Message{Role: "assistant", Content: "Hi"}))
+}
+
+func TestPrepareChatSessionsDisabled(t *testing.T) {
+	var sessions *sessionStore
+	req := &headerRequest{headers: micro.Headers{llm.SessionIDHeader: []string{"abc"}}}
+
+	_, err := sessions.prepareChat(context.Background(), req, &api.ChatRequest{})
+
+	assert.ErrorIs(t, err, errSessionsDisabled)
+}
+
+func TestSessionErrorCode(t *testing.T) {
+	assert.Equal(t, "404", sessionErrorCode(fmt.Errorf("%w: 'abc'", errSessionNotFound)))
+	assert.Equal(t, "501", sessionErrorCode(errSessionsDisabled))
+	assert.Equal(t, "400", sessionErrorCode(jetstream.ErrInvalidKey))
+	assert.Equal(t, "500", sessionErrorCode(fmt.Errorf("connection closed")))
+}
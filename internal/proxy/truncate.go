@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ollama/ollama/api"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TruncateHeader is the NATS header of a chat request selecting how the history is shortened if it
+	// exceeds the context window of the model: "drop_oldest", "keep_last:<n>" or "summarize".
+	TruncateHeader = "Nats-Llm-Truncate"
+
+	// TruncatedHeader is the NATS response header reporting which messages were removed from the history,
+	// e.g. "dropped=4; tokens=1800" or "summarized=4; tokens=1800".
+	TruncatedHeader = "Nats-Llm-Truncated"
+)
+
+const (
+	truncateDropOldest = "drop_oldest"
+	truncateKeepLast   = "keep_last"
+	truncateSummarize  = "summarize"
+)
+
+// summaryPrompt instructs the summary model to condense the removed part of a conversation.
+const summaryPrompt = "Summarize the following conversation concisely. Keep all facts, decisions and open questions needed to continue it.\n\n"
+
+// truncationStrategy is a parsed TruncateHeader.
+type truncationStrategy struct {
+	name     string
+	keepLast int
+}
+
+// truncation describes the messages removed from the history of a chat request.
+type truncation struct {
+	messages   int
+	tokens     int
+	summarized bool
+}
+
+func (t truncation) String() string {
+	if t.summarized {
+		return fmt.Sprintf("summarized=%d; tokens=%d", t.messages, t.tokens)
+	}
+	return fmt.Sprintf("dropped=%d; tokens=%d", t.messages, t.tokens)
+}
+
+// summarizeFunc summarizes the given messages with the summary model of a proxy.
+type summarizeFunc func(ctx context.Context, messages []api.Message) (string, error)
+
+func parseTruncationStrategy(header string) (*truncationStrategy, error) {
+	name, value, hasValue := strings.Cut(strings.TrimSpace(header), ":")
+	switch name {
+	case "":
+		return nil, nil
+	case truncateDropOldest, truncateSummarize:
+		if hasValue {
+			return nil, fmt.Errorf("truncation strategy '%s' does not take a value", name)
+		}
+		return &truncationStrategy{name: name}, nil
+	case truncateKeepLast:
+		keepLast, err := strconv.Atoi(value)
+		if err != nil || keepLast < 1 {
+			return nil, fmt.Errorf("truncation strategy '%s' requires a positive number of messages, e.g. '%s:10'", name, name)
+		}
+		return &truncationStrategy{name: name, keepLast: keepLast}, nil
+	}
+	return nil, fmt.Errorf("unknown truncation strategy '%s', expecting '%s', '%s:<n>' or '%s'", name, truncateDropOldest, truncateKeepLast, truncateSummarize)
+}
+
+// truncateChat shortens the history of a chat request according to the strategy. Leading system messages
+// and the last turn are always kept. "keep_last" is applied regardless of the token budget, the other
+// strategies only remove as many of the oldest messages as needed to fit into the budget.
+func truncateChat(ctx context.Context, reqData *api.ChatRequest, strategy *truncationStrategy, budget int, summarize summarizeFunc) (*truncation, error) {
+	if strategy == nil {
+		return nil, nil
+	}
+
+	messages := reqData.Messages
+	systemCount := 0
+	for systemCount < len(messages) && strings.ToLower(messages[systemCount].Role) == "system" {
+		systemCount++
+	}
+	// The last turn starts with the last user message, so a trailing assistant tool call and its
+	// results are kept together with the user message they answer:
+	lastTurn := systemCount
+	for i := len(messages) - 1; i >= systemCount; i-- {
+		if strings.ToLower(messages[i].Role) == "user" {
+			lastTurn = i
+			break
+		}
+	}
+
+	// Index of the first message kept after the system messages:
+	start := systemCount
+	switch strategy.name {
+	case truncateKeepLast:
+		start = min(lastTurn, max(systemCount, len(messages)-strategy.keepLast))
+	default:
+		excess := estimateMessageTokens(messages...) - budget
+		for start < lastTurn && excess > 0 {
+			excess -= estimateMessageTokens(messages[start])
+			start++
+		}
+	}
+
+	// Tool results must not be separated from their tool calls, so the kept history starts with a user
+	// message. lastTurn is a user message, unless the history has none:
+	for start < lastTurn && strings.ToLower(messages[start].Role) != "user" {
+		start++
+	}
+	if start == systemCount {
+		return nil, nil
+	}
+
+	removed := messages[systemCount:start]
+	result := &truncation{
+		messages: len(removed),
+		tokens:   estimateMessageTokens(removed...),
+	}
+	kept := append([]api.Message{}, messages[:systemCount]...)
+	if strategy.name == truncateSummarize {
+		if summarize == nil {
+			return nil, errors.New("summarizing the history is not supported by this proxy")
+		}
+		summary, err := summarize(ctx, removed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot summarize the history: %w", err)
+		}
+		kept = append(kept, api.Message{
+			Role:    "system",
+			Content: "Summary of the earlier conversation:\n" + summary,
+		})
+		result.summarized = true
+	}
+	reqData.Messages = append(kept, messages[start:]...)
+	return result, nil
+}
+
+// estimateMessageTokens roughly estimates the number of tokens of the given messages, using the same
+// assumptions as estimateTokens.
+func estimateMessageTokens(messages ...api.Message) int {
+	characters := 0
+	mediaParts := 0
+	for _, message := range messages {
+		characters += len(message.Content) + len(message.Thinking)
+		mediaParts += len(message.Images)
+		for _, toolCall := range message.ToolCalls {
+			characters += len(toolCall.Function.Name) + jsonLength(toolCall.Function.Arguments)
+		}
+	}
+	return characters/4 + mediaParts*tokensPerMediaPart
+}
+
+// summaryTranscript renders messages as plain text transcript for the summary model.
+func summaryTranscript(messages []api.Message) string {
+	var transcript strings.Builder
+	transcript.WriteString(summaryPrompt)
+	for _, message := range messages {
+		content := message.Content
+		for _, toolCall := range message.ToolCalls {
+			content += fmt.Sprintf(" [calls %s with %v]", toolCall.Function.Name, toolCall.Function.Arguments.String())
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", strings.ToLower(message.Role), strings.TrimSpace(content))
+	}
+	return transcript.String()
+}
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestParseTruncationStrategy(t *testing.T) {
+	tt := []struct {
+		testName         string
+		inHeader         string
+		expectedStrategy *truncationStrategy
+		expectedError    bool
+	}{
+		{testName: "no strategy", inHeader: "", expectedStrategy: nil},
+		{testName: "drop oldest", inHeader: "drop_oldest", expectedStrategy: &truncationStrategy{name: truncateDropOldest}},
+		{testName: "keep last", inHeader: "keep_last:10", expectedStrategy: &truncationStrategy{name: truncateKeepLast, keepLast: 10}},
+		{testName: "summarize", inHeader: " summarize ", expectedStrategy: &truncationStrategy{name: truncateSummarize}},
+		{testName: "keep last without number", inHeader: "keep_last", expectedError: true},
+		{testName: "keep last zero", inHeader: "keep_last:0", expectedError: true},
+		{testName: "unexpected value", inHeader: "drop_oldest:3", expectedError: true},
+		{testName: "unknown strategy", inHeader: "drop_newest", expectedError: true},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			strategy, err := parseTruncationStrategy(td.inHeader)
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedStrategy, strategy)
+		})
+	}
+}
+
+func truncationTestMessages() []api.Message {
+	long := strings.Repeat("a", 400)
+	return []api.Message{
+		{Role: "system", Content: "You are a weather bot."},
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: long},
+		{Role: "user", Content: "What is the temperature in Bern?"},
+		{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_temperature"}}}},
+		{Role: "tool", Content: "21 degrees celsius"},
+		{Role: "assistant", Content: "It is 21 degrees celsius."},
+		{Role: "user", Content: "And in Zurich?"},
+	}
+}
+
+func TestTruncateChat(t *testing.T) {
+	messages := truncationTestMessages()
+	tt := []struct {
+		testName           string
+		inStrategy         *truncationStrategy
+		inBudget           int
+		expectedMessages   []api.Message
+		expectedTruncation *truncation
+	}{
+		{
+			testName:         "fits into the budget",
+			inStrategy:       &truncationStrategy{name: truncateDropOldest},
+			inBudget:         1000,
+			expectedMessages: messages,
+		},
+		{
+			testName:           "drop oldest",
+			inStrategy:         &truncationStrategy{name: truncateDropOldest},
+			inBudget:           100,
+			expectedMessages:   append([]api.Message{messages[0]}, messages[3:]...),
+			expectedTruncation: &truncation{messages: 2, tokens: 200},
+		},
+		{
+			testName:           "keep last without separating tool results",
+			inStrategy:         &truncationStrategy{name: truncateKeepLast, keepLast: 3},
+			inBudget:           1000,
+			expectedMessages:   []api.Message{messages[0], messages[7]},
+			expectedTruncation: &truncation{messages: 6, tokens: 223},
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			reqData := &api.ChatRequest{Messages: truncationTestMessages()}
+			result, err := truncateChat(context.Background(), reqData, td.inStrategy, td.inBudget, nil)
+
+			assert.NoError(t, err)
+			assert.Equal(t, td.expectedTruncation, result)
+			assert.Equal(t, td.expectedMessages, reqData.Messages)
+		})
+	}
+}
+
+func TestTruncateChatTrailingToolResults(t *testing.T) {
+	long := strings.Repeat("a", 400)
+	toolCall := func(name string) api.ToolCall {
+		return api.ToolCall{Function: api.ToolCallFunction{Name: name}}
+	}
+	tt := []struct {
+		testName         string
+		inMessages       []api.Message
+		inStrategy       *truncationStrategy
+		expectedMessages []api.Message
+	}{
+		{
+			testName: "drop oldest keeps the tool call",
+			inMessages: []api.Message{
+				{Role: "user", Content: long},
+				{Role: "assistant", ToolCalls: []api.ToolCall{toolCall("get_temperature")}},
+				{Role: "tool", Content: long},
+			},
+			inStrategy: &truncationStrategy{name: truncateDropOldest},
+		},
+		{
+			testName: "keep last keeps parallel tool results together",
+			inMessages: []api.Message{
+				{Role: "user", Content: "Hello"},
+				{Role: "assistant", Content: "Hi"},
+				{Role: "user", Content: "How warm is it in Bern and Zurich?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{toolCall("get_temperature"), toolCall("get_temperature")}},
+				{Role: "tool", Content: "21 degrees celsius"},
+				{Role: "tool", Content: "19 degrees celsius"},
+			},
+			inStrategy: &truncationStrategy{name: truncateKeepLast, keepLast: 1},
+			expectedMessages: []api.Message{
+				{Role: "user", Content: "How warm is it in Bern and Zurich?"},
+				{Role: "assistant", ToolCalls: []api.ToolCall{toolCall("get_temperature"), toolCall("get_temperature")}},
+				{Role: "tool", Content: "21 degrees celsius"},
+				{Role: "tool", Content: "19 degrees celsius"},
+			},
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			reqData := &api.ChatRequest{Messages: td.inMessages}
+			_, err := truncateChat(context.Background(), reqData, td.inStrategy, 10, nil)
+
+			assert.NoError(t, err)
+			expectedMessages := td.expectedMessages
+			if expectedMessages == nil {
+				expectedMessages = td.inMessages
+			}
+			assert.Equal(t, expectedMessages, reqData.Messages)
+		})
+	}
+}
+
+func TestTruncateChatSummarize(t *testing.T) {
+	reqData := &api.ChatRequest{Messages: truncationTestMessages()}
+	var summarized []api.Message
+	summarize := func(ctx context.Context, messages []api.Message) (string, error) {
+		summarized = messages
+		return "The user chatted about the letter a.", nil
+	}
+
+	result, err := truncateChat(context.Background(), reqData, &truncationStrategy{name: truncateSummarize}, 100, summarize)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &truncation{messages: 2, tokens: 200, summarized: true}, result)
+	assert.Len(t, summarized, 2)
+	assert.Equal(t, api.Message{Role: "system", Content: "Summary of the earlier conversation:\nThe user chatted about the letter a."}, reqData.Messages[1])
+	assert.Equal(t, "What is the temperature in Bern?", reqData.Messages[2].Content)
+	assert.Equal(t, "summarized=2; tokens=200", result.String())
+}
+
+func TestModelContextLength(t *testing.T) {
+	assert.Equal(t, 131072, modelContextLength(map[string]any{
+		"general.architecture":  "gemma3",
+		"gemma3.context_length": float64(131072),
+	}))
+	assert.Equal(t, 0, modelContextLength(map[string]any{}))
+}
@@ -0,0 +1,173 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/invopop/jsonschema"
+	"github.com/ollama/ollama/api"
+	"sync"
+)
+
+var (
+	// ErrMaxSteps is returned by Agent.Run if the LLM still calls tools after the maximum number of steps.
+	ErrMaxSteps = errors.New("agent reached the maximum number of steps")
+
+	// ErrDuplicateTool is returned when a tool is registered under the name of an already registered tool.
+	ErrDuplicateTool = errors.New("a tool with this name is already registered")
+)
+
+// ToolHandler executes a tool call and returns the content of the tool message sent back to the LLM.
+type ToolHandler func(ctx context.Context, args api.ToolCallFunctionArguments) (string, error)
+
+// Agent runs the tool calling loop of a chat: it sends the request, executes the tools called by the LLM,
+// appends their results and repeats until the LLM answers without calling a tool.
+type Agent struct {
+	llm      LLM
+	tools    []api.Tool
+	handlers map[string]ToolHandler
+
+	// MaxSteps limits the number of chat requests of a single run.
+	MaxSteps int
+
+	// Parallel executes the tool calls of a single response concurrently.
+	Parallel bool
+}
+
+func NewAgent(llm LLM) *Agent {
+	return &Agent{
+		llm:      llm,
+		handlers: map[string]ToolHandler{},
+		MaxSteps: 10,
+	}
+}
+
+// AddTool registers a tool and the handler executing its calls. Tool names must be unique, as the LLM
+// calls tools by name.
+func (a *Agent) AddTool(tool api.Tool, handler ToolHandler) error {
+	if _, ok := a.handlers[tool.Function.Name]; ok {
+		return fmt.Errorf("%w: '%s'", ErrDuplicateTool, tool.Function.Name)
+	}
+	if tool.Type == "" {
+		tool.Type = "function"
+	}
+	a.tools = append(a.tools, tool)
+	a.handlers[tool.Function.Name] = handler
+	return nil
+}
+
+// RegisterTool registers a Go function as tool. The parameters of the tool are derived from the argument
+// type T, the result of the function is sent to the LLM as is if it is a string, otherwise as JSON.
+func RegisterTool[T any](a *Agent, name string, description string, fn func(ctx context.Context, args T) (any, error)) error {
+	parameters, err := toolParameters[T]()
+	if err != nil {
+		return fmt.Errorf("tool '%s': %w", name, err)
+	}
+
+	return a.AddTool(api.Tool{
+		Type: "function",
+		Function: api.ToolFunction{
+			Name:        name,
+			Description: description,
+			Parameters:  parameters,
+		},
+	}, func(ctx context.Context, rawArgs api.ToolCallFunctionArguments) (string, error) {
+		var args T
+		data, err := json.Marshal(rawArgs)
+		if err != nil {
+			return "", err
+		}
+		err = json.Unmarshal(data, &args)
+		if err != nil {
+			return "", fmt.Errorf("invalid arguments: %w", err)
+		}
+
+		result, err := fn(ctx, args)
+		if err != nil {
+			return "", err
+		}
+		if text, ok := result.(string); ok {
+			return text, nil
+		}
+		content, err := json.Marshal(result)
+		return string(content), err
+	})
+}
+
+// toolParameters derives the parameters of a tool from the JSON schema of the argument type.
+func toolParameters[T any]() (api.ToolFunctionParameters, error) {
+	var parameters api.ToolFunctionParameters
+	reflector := jsonschema.Reflector{DoNotReference: true}
+	var args T
+	data, err := json.Marshal(reflector.Reflect(&args))
+	if err != nil {
+		return parameters, err
+	}
+	err = json.Unmarshal(data, &parameters)
+	if err != nil {
+		return parameters, err
+	}
+	if parameters.Type != "object" {
+		return parameters, fmt.Errorf("arguments must be a struct but were '%s'", parameters.Type)
+	}
+	return parameters, nil
+}
+
+// Run sends the chat request with the registered tools and executes tool calls until the LLM returns a
+// final answer. The messages of the request are extended with the responses and tool results, so the
+// request can be used to continue the conversation.
+func (a *Agent) Run(ctx context.Context, req *api.ChatRequest) (api.ChatResponse, error) {
+	req.Tools = a.tools
+	for step := 0; step < a.MaxSteps; step++ {
+		resp, err := a.llm.Chat(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+		req.Messages = append(req.Messages, resp.Message)
+		if len(resp.Message.ToolCalls) == 0 {
+			return resp, nil
+		}
+		req.Messages = append(req.Messages, a.callTools(ctx, resp.Message.ToolCalls)...)
+	}
+	return api.ChatResponse{}, fmt.Errorf("%w (%d)", ErrMaxSteps, a.MaxSteps)
+}
+
+// callTools executes the tool calls and returns their results in the order of the calls. Errors are
+// returned to the LLM as result, so it can react to them.
+func (a *Agent) callTools(ctx context.Context, toolCalls []api.ToolCall) []api.Message {
+	results := make([]api.Message, len(toolCalls))
+	var wg sync.WaitGroup
+	for i, toolCall := range toolCalls {
+		call := func() {
+			results[i] = api.Message{
+				Role:     "tool",
+				ToolName: toolCall.Function.Name,
+				Content:  a.callTool(ctx, toolCall.Function),
+			}
+		}
+		if !a.Parallel {
+			call()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			call()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (a *Agent) callTool(ctx context.Context, function api.ToolCallFunction) string {
+	handler, ok := a.handlers[function.Name]
+	if !ok {
+		return fmt.Sprintf("error: unknown tool '%s'", function.Name)
+	}
+	content, err := handler(ctx, function.Arguments)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return content
+}
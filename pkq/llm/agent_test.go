@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// scriptedLLM returns the given responses in order and records the requests.
+type scriptedLLM struct {
+	responses []api.Message
+	requests  []api.ChatRequest
+}
+
+func (s *scriptedLLM) Chat(ctx context.Context, req *api.ChatRequest) (api.ChatResponse, error) {
+	s.requests = append(s.requests, *req)
+	message := s.responses[0]
+	s.responses = s.responses[1:]
+	return api.ChatResponse{Message: message, Done: true}, nil
+}
+
+func (s *scriptedLLM) Embed(ctx context.Context, req *api.EmbedRequest) (api.EmbedResponse, error) {
+	return api.EmbedResponse{}, nil
+}
+
+func (s *scriptedLLM) Show(ctx context.Context, req *api.ShowRequest) (api.ShowResponse, error) {
+	return api.ShowResponse{}, nil
+}
+
+type temperatureArgs struct {
+	City string `json:"city" jsonschema:"description=Name of the city"`
+	Unit string `json:"unit,omitempty"`
+}
+
+func TestAgentRun(t *testing.T) {
+	llm := &scriptedLLM{responses: []api.Message{
+		{Role: "assistant", ToolCalls: []api.ToolCall{
+			{Function: api.ToolCallFunction{Name: "get_temperature", Arguments: api.ToolCallFunctionArguments{"city": "Bern"}}},
+			{Function: api.ToolCallFunction{Name: "get_humidity", Arguments: api.ToolCallFunctionArguments{"city": "Bern"}}},
+			{Function: api.ToolCallFunction{Name: "get_temperature", Arguments: api.ToolCallFunctionArguments{"city": "Atlantis"}}},
+		}},
+		{Role: "assistant", Content: "It is 21 degrees celsius in Bern."},
+	}}
+	agent := NewAgent(llm)
+	agent.Parallel = true
+	err := RegisterTool(agent, "get_temperature", "Get the temperature of a city", func(ctx context.Context, args temperatureArgs) (any, error) {
+		if args.City != "Bern" {
+			return nil, errors.New("unknown city")
+		}
+		return map[string]any{"temperature": 21}, nil
+	})
+	assert.NoError(t, err)
+
+	req := &api.ChatRequest{Messages: []api.Message{{Role: "user", Content: "How warm is it in Bern?"}}}
+	resp, err := agent.Run(context.Background(), req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "It is 21 degrees celsius in Bern.", resp.Message.Content)
+	assert.Len(t, llm.requests, 2)
+	assert.Equal(t, api.ToolFunctionParameters{
+		Type:     "object",
+		Required: []string{"city"},
+		Properties: map[string]api.ToolProperty{
+			"city": {Type: api.PropertyType{"string"}, Description: "Name of the city"},
+			"unit": {Type: api.PropertyType{"string"}},
+		},
+	}, llm.requests[0].Tools[0].Function.Parameters)
+	assert.Equal(t, []api.Message{
+		{Role: "tool", ToolName: "get_temperature", Content: `{"temperature":21}`},
+		{Role: "tool", ToolName: "get_humidity", Content: "error: unknown tool 'get_humidity'"},
+		{Role: "tool", ToolName: "get_temperature", Content: "error: unknown city"},
+	}, req.Messages[2:5])
+	assert.Len(t, req.Messages, 6)
+}
+
+func TestAgentRunMaxSteps(t *testing.T) {
+	toolCall := api.Message{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{Name: "get_temperature"}}}}
+	llm := &scriptedLLM{responses: []api.Message{toolCall, toolCall}}
+	agent := NewAgent(llm)
+	agent.MaxSteps = 2
+
+	_, err := agent.Run(context.Background(), &api.ChatRequest{})
+
+	assert.ErrorIs(t, err, ErrMaxSteps)
+}
+
+func TestRegisterToolInvalidArguments(t *testing.T) {
+	err := RegisterTool(NewAgent(&scriptedLLM{}), "count", "Count", func(ctx context.Context, args []string) (any, error) {
+		return len(args), nil
+	})
+
+	assert.Error(t, err)
+}
+
+func TestRegisterToolDuplicateName(t *testing.T) {
+	agent := NewAgent(&scriptedLLM{})
+	tool := func(result string) func(ctx context.Context, args temperatureArgs) (any, error) {
+		return func(ctx context.Context, args temperatureArgs) (any, error) {
+			return result, nil
+		}
+	}
+
+	assert.NoError(t, RegisterTool(agent, "get_temperature", "Get the temperature of a city", tool("first")))
+	err := RegisterTool(agent, "get_temperature", "Get the temperature of a city", tool("second"))
+
+	assert.ErrorIs(t, err, ErrDuplicateTool)
+	assert.Len(t, agent.tools, 1)
+	assert.Equal(t, "first", agent.callTool(context.Background(), api.ToolCallFunction{Name: "get_temperature"}))
+}
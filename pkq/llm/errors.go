@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// ServiceError is returned if a proxy answered a request with an error. Data holds the optional
+// error details sent by the proxy, e.g. the SafetyFeedback of a blocked Gemini request.
+type ServiceError struct {
+	Code        string
+	Description string
+	Data        []byte
+}
+
+func (e *ServiceError) Error() string {
+	return fmt.Sprintf("service error %s: %s", e.Code, e.Description)
+}
+
+// SafetyFeedback decodes the data of the error as SafetyFeedback. It returns false if the error
+// does not carry any safety feedback.
+func (e *ServiceError) SafetyFeedback() (SafetyFeedback, bool) {
+	var feedback SafetyFeedback
+	if len(e.Data) == 0 || json.Unmarshal(e.Data, &feedback) != nil || feedback.BlockReason == "" {
+		return SafetyFeedback{}, false
+	}
+	return feedback, true
+}
+
+// serviceError returns a ServiceError if the message is an error response of a micro service.
+func serviceError(msg *nats.Msg) error {
+	code := msg.Header.Get(micro.ErrorCodeHeader)
+	if code == "" {
+		return nil
+	}
+	return &ServiceError{
+		Code:        code,
+		Description: msg.Header.Get(micro.ErrorHeader),
+		Data:        msg.Data,
+	}
+}
@@ -7,11 +7,58 @@ import (
 )
 
 const (
-	geminiChatSubject  = "gemini.chat"
-	geminiEmbedSubject = "gemini.embed"
-	geminiShowSubject  = "gemini.show"
+	geminiChatSubject        = "gemini.chat"
+	geminiEmbedSubject       = "gemini.embed"
+	geminiShowSubject        = "gemini.show"
+	geminiListSubject        = "gemini.list"
+	geminiCountTokensSubject = "gemini.count_tokens"
 )
 
+// GeminiModel is an Ollama style model description extended with the token limits and
+// supported actions of a Gemini model.
+type GeminiModel struct {
+	api.ListModelResponse
+	InputTokenLimit  int32    `json:"input_token_limit,omitempty"`
+	OutputTokenLimit int32    `json:"output_token_limit,omitempty"`
+	SupportedActions []string `json:"supported_actions,omitempty"`
+}
+
+// GeminiListResponse is the response of the gemini.list endpoint. It can also be decoded as an api.ListResponse.
+type GeminiListResponse struct {
+	Models []GeminiModel `json:"models"`
+}
+
+// SafetyRating is the rating of a prompt or candidate for a single harm category.
+type SafetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability,omitempty"`
+	Severity    string `json:"severity,omitempty"`
+	Blocked     bool   `json:"blocked,omitempty"`
+}
+
+// GeminiChatResponse is the response of the gemini.chat endpoint. It is an api.ChatResponse extended with
+// the safety ratings of the returned candidate and any further candidates as alternatives.
+type GeminiChatResponse struct {
+	api.ChatResponse
+	SafetyRatings []SafetyRating      `json:"safety_ratings,omitempty"`
+	Alternatives  []GeminiAlternative `json:"alternatives,omitempty"`
+}
+
+// GeminiAlternative is an additional candidate of a Gemini response, returned if the request asked for
+// more than one candidate with the option candidate_count.
+type GeminiAlternative struct {
+	Message       api.Message    `json:"message"`
+	DoneReason    string         `json:"done_reason,omitempty"`
+	SafetyRatings []SafetyRating `json:"safety_ratings,omitempty"`
+}
+
+// SafetyFeedback is the data of the service error returned if Gemini blocked a prompt or its response.
+type SafetyFeedback struct {
+	BlockReason   string         `json:"block_reason"`
+	Message       string         `json:"message,omitempty"`
+	SafetyRatings []SafetyRating `json:"safety_ratings,omitempty"`
+}
+
 func NewNatsGeminiLLM(nc *nats.Conn, modelName string) *NatsGeminiLLM {
 	return &NatsGeminiLLM{
 		client:    nc,
@@ -31,6 +78,15 @@ func (n *NatsGeminiLLM) Chat(ctx context.Context, req *api.ChatRequest) (api.Cha
 	return response, err
 }
 
+// ChatWithCandidates sends a chat request like Chat, but returns the Gemini specific response including
+// safety ratings and alternative candidates.
+func (n *NatsGeminiLLM) ChatWithCandidates(ctx context.Context, req *api.ChatRequest) (GeminiChatResponse, error) {
+	req.Model = n.modelName
+	var response GeminiChatResponse
+	err := natsRequest(ctx, n.client, geminiChatSubject, req, &response)
+	return response, err
+}
+
 func (n *NatsGeminiLLM) Embed(ctx context.Context, req *api.EmbedRequest) (api.EmbedResponse, error) {
 	req.Model = n.modelName
 	var response api.EmbedResponse
@@ -44,3 +100,17 @@ func (n *NatsGeminiLLM) Show(ctx context.Context, req *api.ShowRequest) (api.Sho
 	err := natsRequest(ctx, n.client, geminiShowSubject, req, &response)
 	return response, err
 }
+
+// CountTokens counts the tokens of the prompt of a chat request.
+func (n *NatsGeminiLLM) CountTokens(ctx context.Context, req *api.ChatRequest) (CountTokensResponse, error) {
+	req.Model = n.modelName
+	var response CountTokensResponse
+	err := natsRequest(ctx, n.client, geminiCountTokensSubject, req, &response)
+	return response, err
+}
+
+func (n *NatsGeminiLLM) List(ctx context.Context) (GeminiListResponse, error) {
+	var response GeminiListResponse
+	err := natsRequest(ctx, n.client, geminiListSubject, &ListRequest{}, &response)
+	return response, err
+}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/invopop/jsonschema"
+	"github.com/ollama/ollama/api"
+)
+
+// LLM is implemented by the clients of all LLMs exposed via NATS.
+type LLM interface {
+	Chat(ctx context.Context, req *api.ChatRequest) (api.ChatResponse, error)
+	Embed(ctx context.Context, req *api.EmbedRequest) (api.EmbedResponse, error)
+	Show(ctx context.Context, req *api.ShowRequest) (api.ShowResponse, error)
+}
+
+// CountTokensResponse is the response of the count_tokens endpoints, counting the tokens of the prompt of
+// a chat request. Estimated is set if the count is not exact, e.g. because the backend could not count
+// the tool declarations.
+type CountTokensResponse struct {
+	Model       string `json:"model"`
+	TotalTokens int    `json:"total_tokens"`
+	Estimated   bool   `json:"estimated,omitempty"`
+}
+
+// ChatJSON requests a response in the shape of T from the given LLM. The JSON schema of T is derived from
+// its type and passed as format of the request, the response is decoded and validated against it.
+func ChatJSON[T any](ctx context.Context, llm LLM, req *api.ChatRequest) (T, error) {
+	var result T
+	reflector := jsonschema.Reflector{DoNotReference: true}
+	schema := reflector.Reflect(&result)
+	format, err := json.Marshal(schema)
+	if err != nil {
+		return result, err
+	}
+	req.Format = format
+
+	resp, err := llm.Chat(ctx, req)
+	if err != nil {
+		return result, err
+	}
+
+	content := []byte(resp.Message.Content)
+	var value any
+	err = json.Unmarshal(content, &value)
+	if err != nil {
+		return result, fmt.Errorf("response is not valid JSON: %w", err)
+	}
+	err = validateRequired(schema, value, "$")
+	if err != nil {
+		return result, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&result)
+	if err != nil {
+		return result, fmt.Errorf("response does not match the expected type: %w", err)
+	}
+	return result, nil
+}
+
+// validateRequired checks that all required properties of the schema, including those of nested objects
+// and arrays of objects, are present in the given value.
+func validateRequired(schema *jsonschema.Schema, value any, path string) error {
+	if schema == nil || value == nil {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range schema.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("response is missing the required property '%s.%s'", path, name)
+			}
+		}
+		if schema.Properties == nil {
+			return nil
+		}
+		for pair := schema.Properties.Oldest(); pair != nil; pair = pair.Next() {
+			err := validateRequired(pair.Value, v[pair.Key], path+"."+pair.Key)
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, item := range v {
+			err := validateRequired(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type forecastDay struct {
+	Day         string  `json:"day"`
+	Temperature float64 `json:"temperature"`
+}
+
+type forecast struct {
+	City string        `json:"city" jsonschema:"description=Name of the city"`
+	Days []forecastDay `json:"days"`
+	Note string        `json:"note,omitempty"`
+}
+
+func TestChatJSONSchema(t *testing.T) {
+	llm := &scriptedLLM{responses: []api.Message{{Role: "assistant", Content: `{"city": "Bern", "days": []}`}}}
+
+	_, err := ChatJSON[forecast](context.Background(), llm, &api.ChatRequest{})
+	assert.NoError(t, err)
+
+	var schema map[string]any
+	assert.NoError(t, json.Unmarshal(llm.requests[0].Format, &schema))
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, []any{"city", "days"}, schema["required"])
+	properties := schema["properties"].(map[string]any)
+	assert.Equal(t, map[string]any{"type": "string", "description": "Name of the city"}, properties["city"])
+	days := properties["days"].(map[string]any)
+	assert.Equal(t, "array", days["type"])
+	assert.Equal(t, []any{"day", "temperature"}, days["items"].(map[string]any)["required"])
+}
+
+func TestChatJSON(t *testing.T) {
+	tt := []struct {
+		testName      string
+		content       string
+		expected      forecast
+		expectedError string
+	}{
+		{
+			testName: "valid response",
+			content:  `{"city": "Bern", "days": [{"day": "Monday", "temperature": 21.5}]}`,
+			expected: forecast{City: "Bern", Days: []forecastDay{{Day: "Monday", Temperature: 21.5}}},
+		},
+		{
+			testName:      "missing required property",
+			content:       `{"days": []}`,
+			expectedError: "response is missing the required property '$.city'",
+		},
+		{
+			testName:      "missing required property of nested object",
+			content:       `{"city": "Bern", "days": [{"day": "Monday"}]}`,
+			expectedError: "response is missing the required property '$.days[0].temperature'",
+		},
+		{
+			testName:      "invalid JSON",
+			content:       `It is 21 degrees celsius in Bern.`,
+			expectedError: "response is not valid JSON",
+		},
+		{
+			testName:      "unknown property",
+			content:       `{"city": "Bern", "days": [], "humidity": 40}`,
+			expectedError: "response does not match the expected type",
+		},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			llm := &scriptedLLM{responses: []api.Message{{Role: "assistant", Content: td.content}}}
+
+			result, err := ChatJSON[forecast](context.Background(), llm, &api.ChatRequest{})
+
+			if td.expectedError != "" {
+				assert.ErrorContains(t, err, td.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expected, result)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hofer/nats-llm/pkq/mcp"
+	"github.com/ollama/ollama/api"
+)
+
+// AddMCPTools registers the tools of an MCP server as tools of the agent. Their tool calls are executed
+// by the server. Tools whose input schema is not a JSON object are skipped. Tools mapped to the name of an
+// already registered tool cause an ErrDuplicateTool error.
+func (a *Agent) AddMCPTools(ctx context.Context, client *mcp.Client) error {
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list MCP tools: %w", err)
+	}
+	for _, tool := range tools {
+		parameters, err := toolParametersFromSchema(string(tool.InputSchema))
+		if err != nil {
+			continue
+		}
+		err = a.AddTool(api.Tool{
+			Type: "function",
+			Function: api.ToolFunction{
+				Name:        natsToolName(tool.Name),
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		}, mcpToolHandler(client, tool.Name))
+		if err != nil {
+			return fmt.Errorf("cannot add the MCP tool '%s': %w", tool.Name, err)
+		}
+	}
+	return nil
+}
+
+// mcpToolHandler calls the tool of the MCP server and returns the text of its result.
+func mcpToolHandler(client *mcp.Client, name string) ToolHandler {
+	return func(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+		if args == nil {
+			args = api.ToolCallFunctionArguments{}
+		}
+		result, err := client.CallTool(ctx, name, args)
+		if err != nil {
+			return "", err
+		}
+		if result.IsError {
+			return "", errors.New(result.Text())
+		}
+		return result.Text(), nil
+	}
+}
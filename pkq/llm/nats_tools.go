@@ -0,0 +1,214 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/ollama/ollama/api"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NatsToolsConfig selects the NATS micro services whose endpoints are offered to an LLM as tools.
+type NatsToolsConfig struct {
+	// Services restricts the discovery to the services with the given names. Without names, the endpoints
+	// of all services except the nats-llm proxies are used.
+	Services []string
+
+	// DiscoveryTimeout is the time to wait for services to answer the discovery, one second by default.
+	DiscoveryTimeout time.Duration
+}
+
+// llmServices are the services of the nats-llm proxies, which are only used as tools if they are selected
+// explicitly by name.
+var llmServices = []string{"NatsOllama", "NatsGemini"}
+
+// NatsTool is a tool executed by sending a request to the subject of a NATS micro endpoint.
+type NatsTool struct {
+	Tool    api.Tool
+	Subject string
+}
+
+// DiscoverNatsTools finds the endpoints of NATS micro services with a JSON schema in their "schema"
+// metadata. The schema is either the JSON schema of the request or, as for the endpoints of nats-llm,
+// an object with the request schema in its "request" field. Endpoints whose request is not a JSON
+// object are skipped.
+func DiscoverNatsTools(ctx context.Context, nc *nats.Conn, config NatsToolsConfig) ([]NatsTool, error) {
+	infos, err := discoverServices(ctx, nc, config)
+	if err != nil {
+		return nil, err
+	}
+
+	tools := []NatsTool{}
+	for _, info := range infos {
+		if len(config.Services) == 0 && slices.Contains(llmServices, info.Name) {
+			continue
+		}
+		for _, endpoint := range info.Endpoints {
+			schema, ok := endpoint.Metadata["schema"]
+			if !ok {
+				continue
+			}
+			// Every instance of a service answers the discovery:
+			if slices.ContainsFunc(tools, func(tool NatsTool) bool { return tool.Subject == endpoint.Subject }) {
+				continue
+			}
+			parameters, err := toolParametersFromSchema(schema)
+			if err != nil {
+				continue
+			}
+
+			description := endpoint.Metadata["description"]
+			if description == "" {
+				description = fmt.Sprintf("Endpoint '%s' of the service '%s'. %s", endpoint.Name, info.Name, info.Description)
+			}
+			tools = append(tools, NatsTool{
+				Subject: endpoint.Subject,
+				Tool: api.Tool{
+					Type: "function",
+					Function: api.ToolFunction{
+						Name:        natsToolName(endpoint.Subject),
+						Description: strings.TrimSpace(description),
+						Parameters:  parameters,
+					},
+				},
+			})
+		}
+	}
+	return tools, nil
+}
+
+// AddNatsTools discovers NATS micro endpoints and registers them as tools of the agent. Their tool
+// calls are executed as requests to the endpoints. Subjects mapped to the name of an already registered
+// tool, e.g. "weather.get" and "weather_get", cause an ErrDuplicateTool error.
+func (a *Agent) AddNatsTools(ctx context.Context, nc *nats.Conn, config NatsToolsConfig) error {
+	tools, err := DiscoverNatsTools(ctx, nc, config)
+	if err != nil {
+		return err
+	}
+	for _, tool := range tools {
+		err = a.AddTool(tool.Tool, natsToolHandler(nc, tool.Subject))
+		if err != nil {
+			return fmt.Errorf("cannot add the tool of subject '%s': %w", tool.Subject, err)
+		}
+	}
+	return nil
+}
+
+// discoverServices collects the info of all service instances answering within the discovery timeout.
+func discoverServices(ctx context.Context, nc *nats.Conn, config NatsToolsConfig) ([]micro.Info, error) {
+	timeout := config.DiscoveryTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	inbox := nc.NewRespInbox()
+	sub, err := nc.SubscribeSync(inbox)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	names := config.Services
+	if len(names) == 0 {
+		names = []string{""}
+	}
+	for _, name := range names {
+		subject, err := micro.ControlSubject(micro.InfoVerb, name, "")
+		if err != nil {
+			return nil, err
+		}
+		err = nc.PublishRequest(subject, inbox, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	infos := []micro.Info{}
+	for {
+		msg, err := sub.NextMsgWithContext(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return infos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var info micro.Info
+		if json.Unmarshal(msg.Data, &info) == nil {
+			infos = append(infos, info)
+		}
+	}
+}
+
+// toolParametersFromSchema reads the tool parameters from the schema metadata of an endpoint.
+func toolParametersFromSchema(schema string) (api.ToolFunctionParameters, error) {
+	var parameters api.ToolFunctionParameters
+	data := []byte(schema)
+
+	var wrapper struct {
+		Request json.RawMessage `json:"request"`
+	}
+	if json.Unmarshal(data, &wrapper) == nil && len(wrapper.Request) > 0 {
+		data = wrapper.Request
+		// The request schema of nats-llm endpoints is an encoded JSON string:
+		var encoded string
+		if json.Unmarshal(data, &encoded) == nil {
+			data = []byte(encoded)
+		}
+	}
+
+	err := json.Unmarshal(data, &parameters)
+	if err != nil {
+		return parameters, err
+	}
+	if parameters.Type != "object" {
+		return parameters, fmt.Errorf("the request must be a JSON object but was '%s'", parameters.Type)
+	}
+	return parameters, nil
+}
+
+// natsToolName derives a valid function name from the subject of an endpoint, e.g. "weather_current"
+// for "weather.current".
+func natsToolName(subject string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, subject)
+}
+
+// natsToolHandler sends the arguments of a tool call as request to the subject and returns the response.
+func natsToolHandler(nc *nats.Conn, subject string) ToolHandler {
+	return func(ctx context.Context, args api.ToolCallFunctionArguments) (string, error) {
+		if args == nil {
+			args = api.ToolCallFunctionArguments{}
+		}
+		data, err := json.Marshal(args)
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Second*30)
+			defer cancel()
+		}
+		msg, err := nc.RequestWithContext(ctx, subject, data)
+		if err != nil {
+			return "", err
+		}
+		err = serviceError(msg)
+		if err != nil {
+			return "", err
+		}
+		return string(msg.Data), nil
+	}
+}
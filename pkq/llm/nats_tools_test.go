@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"github.com/ollama/ollama/api"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestToolParametersFromSchema(t *testing.T) {
+	expectedParameters := api.ToolFunctionParameters{
+		Type:     "object",
+		Required: []string{"city"},
+		Properties: map[string]api.ToolProperty{
+			"city": {Type: api.PropertyType{"string"}, Description: "Name of the city"},
+		},
+	}
+	requestSchema := `{"type": "object", "required": ["city"], "properties": {"city": {"type": "string", "description": "Name of the city"}}}`
+
+	tt := []struct {
+		testName      string
+		inSchema      string
+		expectedError bool
+	}{
+		{testName: "request schema", inSchema: requestSchema},
+		{testName: "request and response schema", inSchema: `{"request": ` + requestSchema + `, "response": {"type": "string"}}`},
+		{testName: "nats-llm schema", inSchema: `{"request": "{\"type\": \"object\", \"required\": [\"city\"], \"properties\": {\"city\": {\"type\": \"string\", \"description\": \"Name of the city\"}}}", "response": "{}"}`},
+		{testName: "no object", inSchema: `{"type": "string"}`, expectedError: true},
+		{testName: "invalid json", inSchema: `{"type": `, expectedError: true},
+	}
+
+	for _, td := range tt {
+		t.Run(td.testName, func(t *testing.T) {
+			parameters, err := toolParametersFromSchema(td.inSchema)
+
+			if td.expectedError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, expectedParameters, parameters)
+		})
+	}
+}
+
+func TestNatsToolName(t *testing.T) {
+	assert.Equal(t, "weather_current", natsToolName("weather.current"))
+	assert.Equal(t, "tools_get-time", natsToolName("tools.get-time"))
+}
@@ -10,9 +10,16 @@ import (
 )
 
 const (
-	ollamaChatSubject  = "ollama.chat"
-	ollamaEmbedSubject = "ollama.embed"
-	ollamaShowSubject  = "ollama.show"
+	ollamaChatSubject        = "ollama.chat"
+	ollamaGenerateSubject    = "ollama.generate"
+	ollamaEmbedSubject       = "ollama.embed"
+	ollamaShowSubject        = "ollama.show"
+	ollamaCountTokensSubject = "ollama.count_tokens"
+	ollamaListSubject        = "ollama.list"
+
+	// AdminTokenHeader is the NATS header carrying the token required by the model management endpoints
+	// of the Ollama proxy.
+	AdminTokenHeader = "Nats-Llm-Admin-Token"
 )
 
 func NewNatsOllamaLLM(nc *nats.Conn, modelName string) *NatsOllamaLLM {
@@ -34,6 +41,14 @@ func (n *NatsOllamaLLM) Chat(ctx context.Context, req *api.ChatRequest) (api.Cha
 	return response, err
 }
 
+// Generate sends a prompt without chat history. Generate requests are only supported by the Ollama proxy.
+func (n *NatsOllamaLLM) Generate(ctx context.Context, req *api.GenerateRequest) (api.GenerateResponse, error) {
+	req.Model = n.modelName
+	var response api.GenerateResponse
+	err := natsRequest(ctx, n.client, ollamaGenerateSubject, req, &response)
+	return response, err
+}
+
 func (n *NatsOllamaLLM) Embed(ctx context.Context, req *api.EmbedRequest) (api.EmbedResponse, error) {
 	req.Model = n.modelName
 	var response api.EmbedResponse
@@ -48,12 +63,43 @@ func (n *NatsOllamaLLM) Show(ctx context.Context, req *api.ShowRequest) (api.Sho
 	return response, err
 }
 
+// CountTokens counts the tokens of the prompt of a chat request.
+func (n *NatsOllamaLLM) CountTokens(ctx context.Context, req *api.ChatRequest) (CountTokensResponse, error) {
+	req.Model = n.modelName
+	var response CountTokensResponse
+	err := natsRequest(ctx, n.client, ollamaCountTokensSubject, req, &response)
+	return response, err
+}
+
+// List lists the models available in Ollama. The model management endpoints of the proxy require
+// a context with the admin token, see ContextWithAdminToken.
+func (n *NatsOllamaLLM) List(ctx context.Context) (api.ListResponse, error) {
+	var response api.ListResponse
+	err := natsRequest(ctx, n.client, ollamaListSubject, &ListRequest{}, &response)
+	return response, err
+}
+
+type adminTokenContextKey struct{}
+
+// ContextWithAdminToken returns a context, which sends the admin token with all requests made with it.
+func ContextWithAdminToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, adminTokenContextKey{}, token)
+}
+
+func adminTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(adminTokenContextKey{}).(string)
+	return token
+}
+
+// ListRequest is the empty request of the list endpoints.
+type ListRequest struct{}
+
 type ApiResponse interface {
-	*api.ShowResponse | *api.EmbedResponse | *api.ChatResponse
+	*api.ShowResponse | *api.EmbedResponse | *api.ChatResponse | *api.GenerateResponse | *api.ListResponse | *GeminiListResponse | *GeminiChatResponse | *Session | *SessionListResponse | *CountTokensResponse
 }
 
 type ApiRequest interface {
-	*api.ShowRequest | *api.EmbedRequest | *api.ChatRequest
+	*api.ShowRequest | *api.EmbedRequest | *api.ChatRequest | *api.GenerateRequest | *ListRequest | *SessionCreateRequest | *SessionRequest
 }
 
 func natsRequest[T ApiRequest, A ApiResponse](ctx context.Context, n *nats.Conn, subject string, req T, resp A) error {
@@ -62,13 +108,30 @@ func natsRequest[T ApiRequest, A ApiResponse](ctx context.Context, n *nats.Conn,
 		return err
 	}
 
-	remainingDuration := time.Second * 30
-	deadline, ok := ctx.Deadline()
-	if ok {
-		remainingDuration = time.Until(deadline)
+	// Requests are cancelled with the context, without deadline they time out after 30 seconds:
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*30)
+		defer cancel()
+	}
+
+	reqMsg := nats.NewMsg(subject)
+	reqMsg.Data = jsonStr
+	sessionID := sessionFromContext(ctx)
+	if sessionID != "" {
+		reqMsg.Header.Set(SessionIDHeader, sessionID)
+	}
+	adminToken := adminTokenFromContext(ctx)
+	if adminToken != "" {
+		reqMsg.Header.Set(AdminTokenHeader, adminToken)
+	}
+
+	msg, err := n.RequestMsgWithContext(ctx, reqMsg)
+	if err != nil {
+		return err
 	}
 
-	msg, err := n.Request(subject, jsonStr, remainingDuration)
+	err = serviceError(msg)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+	"github.com/nats-io/nats.go"
+	"github.com/ollama/ollama/api"
+	"time"
+)
+
+const (
+	sessionCreateSubject = "session.create"
+	sessionGetSubject    = "session.get"
+	sessionDeleteSubject = "session.delete"
+	sessionListSubject   = "session.list"
+
+	// SessionIDHeader is the NATS header of a chat request selecting the session its history is loaded
+	// from and appended to.
+	SessionIDHeader = "Nats-Llm-Session-Id"
+)
+
+// Session is a conversation stored by the proxies. Chat requests sent within a session only contain the
+// new messages, the proxy prepends the stored history and appends the new messages and the response.
+type Session struct {
+	ID        string        `json:"id"`
+	Model     string        `json:"model,omitempty"`
+	Messages  []api.Message `json:"messages,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// SessionCreateRequest is the request of the session.create endpoint. The messages, e.g. a system
+// prompt, start the history of the new session.
+type SessionCreateRequest struct {
+	Model    string        `json:"model,omitempty"`
+	Messages []api.Message `json:"messages,omitempty"`
+}
+
+// SessionRequest is the request of the session.get and session.delete endpoints.
+type SessionRequest struct {
+	ID string `json:"id"`
+}
+
+// SessionListResponse is the response of the session.list endpoint. The sessions are listed without
+// their messages.
+type SessionListResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+type sessionContextKey struct{}
+
+// ContextWithSession returns a context, which sends all chat requests made with it within the given session.
+func ContextWithSession(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, sessionID)
+}
+
+func sessionFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionContextKey{}).(string)
+	return sessionID
+}
+
+func NewNatsSessions(nc *nats.Conn) *NatsSessions {
+	return &NatsSessions{
+		client: nc,
+	}
+}
+
+// NatsSessions manages the sessions stored by the proxies.
+type NatsSessions struct {
+	client *nats.Conn
+}
+
+func (n *NatsSessions) Create(ctx context.Context, req *SessionCreateRequest) (Session, error) {
+	var response Session
+	err := natsRequest(ctx, n.client, sessionCreateSubject, req, &response)
+	return response, err
+}
+
+func (n *NatsSessions) Get(ctx context.Context, id string) (Session, error) {
+	var response Session
+	err := natsRequest(ctx, n.client, sessionGetSubject, &SessionRequest{ID: id}, &response)
+	return response, err
+}
+
+// Delete removes a session and returns it.
+func (n *NatsSessions) Delete(ctx context.Context, id string) (Session, error) {
+	var response Session
+	err := natsRequest(ctx, n.client, sessionDeleteSubject, &SessionRequest{ID: id}, &response)
+	return response, err
+}
+
+// List lists all sessions without their messages. It requires a context with the admin token, see
+// ContextWithAdminToken.
+func (n *NatsSessions) List(ctx context.Context) (SessionListResponse, error) {
+	var response SessionListResponse
+	err := natsRequest(ctx, n.client, sessionListSubject, &ListRequest{}, &response)
+	return response, err
+}
@@ -0,0 +1,205 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned for requests to a server whose connection is closed.
+var ErrClosed = errors.New("mcp connection closed")
+
+// closeTimeout is the time the subprocess of a server is given to exit after its stdin is closed, before
+// it is killed.
+var closeTimeout = 5 * time.Second
+
+// Client uses the tools of an MCP server.
+type Client struct {
+	w       *writer
+	closer  io.Closer
+	cmd     *exec.Cmd
+	mu      sync.Mutex
+	nextID  int64
+	pending map[string]chan message
+	done    chan struct{}
+	err     error
+}
+
+// StartClient launches an MCP server as a local subprocess, talking to it via its stdin and stdout, and
+// initializes the connection. The stderr of the server is passed through.
+func StartClient(ctx context.Context, command string, args ...string) (*Client, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start MCP server '%s': %w", command, err)
+	}
+
+	client := NewClient(stdout, stdin)
+	client.cmd = cmd
+	err = client.Initialize(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// NewClient creates a client reading messages from r and writing them to w. Initialize must be called
+// before any tools are used.
+func NewClient(r io.Reader, w io.WriteCloser) *Client {
+	c := &Client{
+		w:       &writer{w: w},
+		closer:  w,
+		pending: map[string]chan message{},
+		done:    make(chan struct{}),
+	}
+	go c.read(r)
+	return c
+}
+
+// Initialize negotiates the protocol version with the server.
+func (c *Client) Initialize(ctx context.Context) error {
+	var result initializeResult
+	err := c.call(ctx, "initialize", initializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      Implementation{Name: "nats-llm", Version: "1.0.0"},
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("cannot initialize MCP connection: %w", err)
+	}
+	return c.w.write(message{JSONRPC: "2.0", Method: "notifications/initialized"})
+}
+
+// ListTools returns all tools offered by the server.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	params := listToolsParams{}
+	for {
+		var result listToolsResult
+		err := c.call(ctx, "tools/list", params, &result)
+		if err != nil {
+			return nil, err
+		}
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		params.Cursor = result.NextCursor
+	}
+}
+
+// CallTool calls a tool of the server with the given JSON arguments.
+func (c *Client) CallTool(ctx context.Context, name string, arguments any) (CallToolResult, error) {
+	args, err := json.Marshal(arguments)
+	if err != nil {
+		return CallToolResult{}, err
+	}
+	var result CallToolResult
+	err = c.call(ctx, "tools/call", callToolParams{Name: name, Arguments: args}, &result)
+	return result, err
+}
+
+// Close closes the connection and waits for the subprocess of the server to exit, if any. A subprocess
+// which does not exit within the close timeout is killed.
+func (c *Client) Close() error {
+	err := c.closer.Close()
+	if c.cmd == nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- c.cmd.Wait()
+	}()
+	select {
+	case waitErr := <-exited:
+		if err == nil {
+			err = waitErr
+		}
+	case <-time.After(closeTimeout):
+		c.cmd.Process.Kill()
+		<-exited
+		err = fmt.Errorf("MCP server did not exit within %v and was killed", closeTimeout)
+	}
+	return err
+}
+
+func (c *Client) call(ctx context.Context, method string, params any, result any) error {
+	paramsData, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.nextID++
+	id := strconv.FormatInt(c.nextID, 10)
+	respChan := make(chan message, 1)
+	c.pending[id] = respChan
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	err = c.w.write(message{JSONRPC: "2.0", ID: json.RawMessage(id), Method: method, Params: paramsData})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-respChan:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-c.done:
+		return c.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read passes the responses of the server on to the waiting calls until the connection is closed.
+// Requests and notifications sent by the server are ignored, as no client capabilities are offered.
+func (c *Client) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.Method != "" || msg.ID == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		respChan, ok := c.pending[string(msg.ID)]
+		c.mu.Unlock()
+		if ok {
+			respChan <- msg
+		}
+	}
+
+	c.err = ErrClosed
+	if err := scanner.Err(); err != nil {
+		c.err = fmt.Errorf("%w: %v", ErrClosed, err)
+	}
+	close(c.done)
+}
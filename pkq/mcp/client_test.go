@@ -0,0 +1,122 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// connect runs the server on one end of a pipe and returns an initialized client on the other end.
+func connect(t *testing.T, server *Server) *Client {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	go func() {
+		server.Serve(context.Background(), serverIn, serverOut)
+		serverOut.Close()
+	}()
+
+	client := NewClient(clientIn, clientOut)
+	t.Cleanup(func() { client.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.NoError(t, client.Initialize(ctx))
+	return client
+}
+
+func TestClientServer(t *testing.T) {
+	server := NewServer("test", "1.0.0")
+	server.AddTool(Tool{
+		Name:        "echo",
+		Description: "Returns the text",
+		InputSchema: json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}}}`),
+	}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		var args struct {
+			Text string `json:"text"`
+		}
+		err := json.Unmarshal(arguments, &args)
+		return args.Text, err
+	})
+	server.AddTool(Tool{Name: "fail", InputSchema: json.RawMessage(`{"type":"object"}`)}, func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		return "", errors.New("tool failed")
+	})
+	client := connect(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	tools, err := client.ListTools(ctx)
+	assert.NoError(t, err)
+	if !assert.Len(t, tools, 2) {
+		return
+	}
+	assert.Equal(t, []string{"echo", "fail"}, []string{tools[0].Name, tools[1].Name})
+	assert.Equal(t, "Returns the text", tools[0].Description)
+
+	result, err := client.CallTool(ctx, "echo", map[string]any{"text": "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, CallToolResult{Content: []Content{{Type: "text", Text: "hello"}}}, result)
+
+	result, err = client.CallTool(ctx, "fail", map[string]any{})
+	assert.NoError(t, err)
+	assert.True(t, result.IsError)
+	assert.Equal(t, "tool failed", result.Text())
+
+	_, err = client.CallTool(ctx, "unknown", map[string]any{})
+	var rpcErr *Error
+	assert.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, codeInvalidParams, rpcErr.Code)
+}
+
+func TestServerUnknownMethod(t *testing.T) {
+	client := connect(t, NewServer("test", "1.0.0"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	err := client.call(ctx, "resources/list", struct{}{}, &struct{}{})
+	var rpcErr *Error
+	assert.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, codeMethodNotFound, rpcErr.Code)
+}
+
+func TestClientClosedConnection(t *testing.T) {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	go func() {
+		io.Copy(io.Discard, serverIn)
+	}()
+	client := NewClient(clientIn, clientOut)
+	serverOut.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err := client.ListTools(ctx)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestClientCloseKillsServer(t *testing.T) {
+	closeTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { closeTimeout = 5 * time.Second })
+
+	// The server ignores that its stdin is closed:
+	cmd := exec.Command("sleep", "60")
+	stdin, err := cmd.StdinPipe()
+	assert.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	assert.NoError(t, err)
+	if !assert.NoError(t, cmd.Start()) {
+		return
+	}
+	client := NewClient(stdout, stdin)
+	client.cmd = cmd
+
+	start := time.Now()
+	err = client.Close()
+
+	assert.ErrorContains(t, err, "was killed")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.False(t, cmd.ProcessState.Success())
+}
@@ -0,0 +1,142 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// maxMessageSize limits the size of a single message read from the other side.
+const maxMessageSize = 16 * 1024 * 1024
+
+// ToolHandler executes a call of a server tool with the raw JSON arguments and returns its text result.
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Server offers tools to an MCP client.
+type Server struct {
+	info     Implementation
+	tools    []Tool
+	handlers map[string]ToolHandler
+}
+
+func NewServer(name string, version string) *Server {
+	return &Server{
+		info:     Implementation{Name: name, Version: version},
+		handlers: map[string]ToolHandler{},
+	}
+}
+
+// AddTool registers a tool and the handler executing its calls.
+func (s *Server) AddTool(tool Tool, handler ToolHandler) {
+	s.tools = append(s.tools, tool)
+	s.handlers[tool.Name] = handler
+}
+
+// Serve reads requests from r and writes the responses to w until r is closed or the context is done.
+// Tool calls are executed concurrently, so a long running call does not block other requests.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out := &writer{w: w}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var msg message
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			out.write(message{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: codeParseError, Message: err.Error()}})
+			continue
+		}
+		// Notifications, e.g. "notifications/initialized", and responses need no answer:
+		if msg.ID == nil || msg.Method == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out.write(s.handle(ctx, msg))
+		}()
+	}
+	return scanner.Err()
+}
+
+func (s *Server) handle(ctx context.Context, req message) message {
+	result, err := s.dispatch(ctx, req)
+	resp := message{JSONRPC: "2.0", ID: req.ID}
+	if err != nil {
+		rpcErr, ok := err.(*Error)
+		if !ok {
+			rpcErr = &Error{Code: codeInternalError, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+
+	resp.Result, err = json.Marshal(result)
+	if err != nil {
+		resp.Result = nil
+		resp.Error = &Error{Code: codeInternalError, Message: err.Error()}
+	}
+	return resp
+}
+
+func (s *Server) dispatch(ctx context.Context, req message) (any, error) {
+	switch req.Method {
+	case "initialize":
+		return initializeResult{
+			ProtocolVersion: ProtocolVersion,
+			Capabilities:    map[string]any{"tools": map[string]any{}},
+			ServerInfo:      s.info,
+		}, nil
+	case "ping":
+		return struct{}{}, nil
+	case "tools/list":
+		return listToolsResult{Tools: s.tools}, nil
+	case "tools/call":
+		var params callToolParams
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return nil, &Error{Code: codeInvalidParams, Message: err.Error()}
+		}
+		handler, ok := s.handlers[params.Name]
+		if !ok {
+			return nil, &Error{Code: codeInvalidParams, Message: fmt.Sprintf("unknown tool '%s'", params.Name)}
+		}
+
+		// Errors of a tool are results, so the model calling it can react to them:
+		text, err := handler(ctx, params.Arguments)
+		if err != nil {
+			return CallToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return CallToolResult{Content: []Content{{Type: "text", Text: text}}}, nil
+	}
+	return nil, &Error{Code: codeMethodNotFound, Message: fmt.Sprintf("method '%s' not found", req.Method)}
+}
+
+// writer serializes the messages written by concurrent handlers, one message per line.
+type writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *writer) write(msg message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.w.Write(append(data, '\n'))
+	return err
+}